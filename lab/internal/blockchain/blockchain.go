@@ -9,7 +9,14 @@ import (
 )
 
 type Blockchain struct {
-	blocks []*Block
+	blocks    []*Block
+	observers []Observer
+	heights   map[string]int // hash -> height
+}
+
+// Observer is notified about every block appended by AddBlock.
+type Observer interface {
+	BlockAdded(block *Block)
 }
 
 func NewBlockchain(blocks []*Block) *Blockchain {
@@ -31,7 +38,16 @@ func NewBlockchain(blocks []*Block) *Blockchain {
 		blocks = []*Block{genesis}
 	}
 
-	return &Blockchain{blocks: blocks}
+	return newChain(blocks)
+}
+
+// newChain indexes the hashes of blocks once, so HeightOf never scans.
+func newChain(blocks []*Block) *Blockchain {
+	heights := make(map[string]int, len(blocks))
+	for i, block := range blocks {
+		heights[block.Hash] = i
+	}
+	return &Blockchain{blocks: blocks, heights: heights}
 }
 
 func (bc *Blockchain) AddBlock(data StudentRecord) (time.Duration, error) {
@@ -49,17 +65,28 @@ func (bc *Blockchain) AddBlock(data StudentRecord) (time.Duration, error) {
 	newBlock := &Block{
 		Index:        nextIndex,
 		Timestamp:    time.Now().Unix(),
-		Data:         data,
 		PreviousHash: prevBlock.Hash,
 	}
+	if data.IssuedAt == 0 {
+		data.IssuedAt = newBlock.Timestamp
+	}
+	newBlock.Data = data
 
 	miner := NewMiner()
 	mineTime := miner.Mine(newBlock, Difficulty)
 
 	bc.blocks = append(bc.blocks, newBlock)
+	bc.heights[newBlock.Hash] = len(bc.blocks) - 1
+	for _, o := range bc.observers {
+		o.BlockAdded(newBlock)
+	}
 	return mineTime, nil
 }
 
+func (bc *Blockchain) Observe(o Observer) {
+	bc.observers = append(bc.observers, o)
+}
+
 func (bc *Blockchain) Blocks() []*Block {
 	return bc.blocks
 }
@@ -68,6 +95,31 @@ func (bc *Blockchain) Length() int {
 	return len(bc.blocks)
 }
 
+func (bc *Blockchain) GetBlock(index int) (*Block, error) {
+	if index < 0 || index >= len(bc.blocks) {
+		return nil, fmt.Errorf("block index %d out of range", index)
+	}
+	return bc.blocks[index], nil
+}
+
+// HeightOf returns the height of the block with the given hash.
+func (bc *Blockchain) HeightOf(hash string) (int, bool) {
+	height, ok := bc.heights[hash]
+	return height, ok
+}
+
+// Prefix returns a new chain made of blocks #0..#height.
+func (bc *Blockchain) Prefix(height int) (*Blockchain, error) {
+	if height < 0 || height >= len(bc.blocks) {
+		return nil, fmt.Errorf("block index %d out of range", height)
+	}
+	blocks := make([]*Block, height+1)
+	copy(blocks, bc.blocks[:height+1])
+	return newChain(blocks), nil
+}
+
+// Search scans the chain for blocks whose record contains keyword in its
+// name, zachetka, group or subject, ignoring case.
 func (bc *Blockchain) Search(keyword string) []*Block {
 	keyword = strings.ToLower(strings.TrimSpace(keyword))
 	if keyword == "" {
@@ -93,24 +145,39 @@ func (bc *Blockchain) Search(keyword string) []*Block {
 }
 
 func (bc *Blockchain) Validate() error {
-	for i := 1; i < len(bc.blocks); i++ {
-		current := bc.blocks[i]
-		prev := bc.blocks[i-1]
+	_, err := ValidPrefix(bc.blocks)
+	return err
+}
+
+// ValidPrefix returns how many leading blocks form a valid chain and the
+// error that stopped the check, if any.
+func ValidPrefix(blocks []*Block) (int, error) {
+	if len(blocks) == 0 {
+		return 0, fmt.Errorf("blockchain has no blocks")
+	}
+
+	if blocks[0].Hash != CalculateHash(blocks[0]) {
+		return 0, fmt.Errorf("genesis block: invalid hash")
+	}
+
+	for i := 1; i < len(blocks); i++ {
+		current := blocks[i]
+		prev := blocks[i-1]
 
 		recalculated := CalculateHash(current)
 
 		if current.Hash != recalculated {
-			return fmt.Errorf("block %d: invalid hash", current.Index)
+			return i, fmt.Errorf("block %d: invalid hash", current.Index)
 		}
 
 		// Check chain linkage
 		if current.PreviousHash != prev.Hash {
-			return fmt.Errorf("block #%d: broken chain link", current.Index)
+			return i, fmt.Errorf("block #%d: broken chain link", current.Index)
 		}
 
 		if !strings.HasPrefix(current.Hash, Difficulty) {
-			return fmt.Errorf("block %d: invalid proof-of-work", current.Index)
+			return i, fmt.Errorf("block %d: invalid proof-of-work", current.Index)
 		}
 	}
-	return nil
+	return len(blocks), nil
 }
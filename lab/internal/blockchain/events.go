@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published on a Bus.
+const (
+	EventBlockAdded       = "BlockAdded"
+	EventChainResolved    = "ChainResolved"
+	EventValidationFailed = "ValidationFailed"
+)
+
+// SubscriberBuffer is how many events a subscriber may fall behind before
+// it misses some.
+const SubscriberBuffer = 64
+
+// Event is something that happened to a chain. Height is the chain's tip
+// after the event: the new block for EventBlockAdded, the merged tip for
+// EventChainResolved.
+type Event struct {
+	Type   string `json:"type"`
+	Chain  string `json:"chain"`
+	Height int    `json:"height"`
+	Time   int64  `json:"time"`
+
+	Block *Block `json:"block,omitempty"`
+
+	// Winner, Chains and Reorg describe a resolve: every chain in Chains
+	// now holds the winner's blocks.
+	Winner string   `json:"winner,omitempty"`
+	Chains []string `json:"chains,omitempty"`
+	Reorg  int      `json:"reorg,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Bus fans events out to its subscribers. Publishing never blocks: a
+// subscriber whose buffer is full misses the event.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]bool)}
+}
+
+// Subscribe returns a channel of every event published from now on and a
+// function that ends the subscription.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, SubscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = true
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		if b.subs[ch] {
+			delete(b.subs, ch)
+			close(ch)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Publish sends e to every subscriber. A nil Bus drops it, so publishers
+// need not check whether anyone listens.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Observer returns an Observer publishing every block added to the named
+// chain as EventBlockAdded.
+func (b *Bus) Observer(chain string) Observer {
+	return busObserver{bus: b, chain: chain}
+}
+
+type busObserver struct {
+	bus   *Bus
+	chain string
+}
+
+func (o busObserver) BlockAdded(block *Block) {
+	o.bus.Publish(Event{Type: EventBlockAdded, Chain: o.chain, Height: block.Index, Block: block})
+}
@@ -43,6 +43,15 @@ func CalculateHash(block *Block) string {
 		block.Nonce,
 	)
 
+	// Provenance fields are hashed only when present, so blocks written
+	// before they existed keep their hashes.
+	if data := block.Data; data.IssuedAt != 0 || data.Origin != "" || len(data.Provenance) > 0 {
+		record += fmt.Sprintf("|%d|%s", data.IssuedAt, data.Origin)
+		for _, hop := range data.Provenance {
+			record += fmt.Sprintf("|%s:%d:%s:%s:%d", hop.Chain, hop.Block, hop.Hash, hop.Into, hop.ResolvedAt)
+		}
+	}
+
 	hash := sha256.Sum256([]byte(record))
 	return hex.EncodeToString(hash[:])
 }
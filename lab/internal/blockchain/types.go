@@ -5,20 +5,37 @@ const (
 )
 
 type StudentRecord struct {
-	ID       string
-	FullName string
-	Zachetka string
-	Group    string
-	Subject  string
-	Course   int
-	Grade    int
+	ID       string `json:"id"`
+	FullName string `json:"full_name"`
+	Zachetka string `json:"zachetka"`
+	Group    string `json:"group"`
+	Subject  string `json:"subject"`
+	Course   int    `json:"course"`
+	Grade    int    `json:"grade"`
+
+	// IssuedAt is when the grade was first recorded and Origin the chain it
+	// was recorded on. Both survive resolves, which re-mine the record into
+	// another chain and append a Hop to Provenance.
+	IssuedAt   int64  `json:"issued_at,omitempty"`
+	Origin     string `json:"origin,omitempty"`
+	Provenance []Hop  `json:"provenance,omitempty"`
+}
+
+// Hop records one resolve that replayed a record: where it was before and
+// which chain it was replayed into.
+type Hop struct {
+	Chain      string `json:"chain"`
+	Block      int    `json:"block"`
+	Hash       string `json:"hash"`
+	Into       string `json:"into"`
+	ResolvedAt int64  `json:"resolved_at"`
 }
 
 type Block struct {
-	Index        int
-	Timestamp    int64
-	Data         StudentRecord
-	PreviousHash string
-	Hash         string
-	Nonce        int
+	Index        int           `json:"index"`
+	Timestamp    int64         `json:"timestamp"`
+	Data         StudentRecord `json:"data"`
+	PreviousHash string        `json:"previous_hash"`
+	Hash         string        `json:"hash"`
+	Nonce        int           `json:"nonce"`
 }
@@ -1,13 +1,19 @@
 package cli
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/index"
 	"github.com/rx3lixir/lab_bc/internal/storage"
 )
 
 type App struct {
-	bc      *blockchain.Blockchain
-	storage storage.Storage
+	bc        *blockchain.Blockchain
+	storage   storage.Storage
+	index     *index.Index
+	indexFile string
 }
 
 func NewApp(storage storage.Storage) (*App, error) {
@@ -23,8 +29,58 @@ func NewApp(storage storage.Storage) (*App, error) {
 		}
 	}
 
+	indexFile := index.PathFor(storage.Filename())
+	idx := index.Load(indexFile)
+	if idx.Sync(bc) {
+		if err := idx.Save(indexFile); err != nil {
+			return nil, err
+		}
+	}
+	bc.Observe(idx)
+
 	return &App{
-		bc:      bc,
-		storage: storage,
+		bc:        bc,
+		storage:   storage,
+		index:     idx,
+		indexFile: indexFile,
 	}, nil
 }
+
+// SearchResult is a search hit: the block and the record within it.
+type SearchResult struct {
+	Block *blockchain.Block `json:"block"`
+	Tx    int               `json:"tx"`
+}
+
+// Search finds the records matching query through the chain's index.
+func (a *App) Search(query string) ([]SearchResult, error) {
+	hits := a.index.Search(query)
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		block, err := a.bc.GetBlock(hit.Block)
+		if err != nil {
+			return nil, fmt.Errorf("search index is out of date: %w", err)
+		}
+		results = append(results, SearchResult{Block: block, Tx: hit.Tx})
+	}
+	return results, nil
+}
+
+// Add mines record into a new block and saves the chain and its index.
+func (a *App) Add(record blockchain.StudentRecord) (*blockchain.Block, time.Duration, error) {
+	miningTime, err := a.bc.AddBlock(record)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to add block: %w", err)
+	}
+
+	if err := a.storage.Save(a.bc); err != nil {
+		return nil, 0, fmt.Errorf("failed to save blockchain: %w", err)
+	}
+
+	if err := a.index.Save(a.indexFile); err != nil {
+		return nil, 0, fmt.Errorf("failed to save search index: %w", err)
+	}
+
+	blocks := a.bc.Blocks()
+	return blocks[len(blocks)-1], miningTime, nil
+}
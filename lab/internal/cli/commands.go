@@ -1,67 +1,548 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/fork"
+	"github.com/rx3lixir/lab_bc/internal/resolve"
+	"github.com/rx3lixir/lab_bc/internal/storage"
 )
 
-func (a *App) CmdList() error {
+// CmdList prints the blocks from one block reference to another; empty
+// references mean the genesis block and the tip.
+func (a *App) CmdList(w io.Writer, forkMgr *fork.Manager, from, to string) error {
 	blocks := a.bc.Blocks()
-	fmt.Printf("Total blocks: %d\n\n", len(blocks))
 
-	for _, block := range blocks {
-		PrintBlock(block)
+	first, last := 0, len(blocks)-1
+	var err error
+	if from != "" {
+		if first, err = forkMgr.ResolveRef(a.bc, from); err != nil {
+			return fmt.Errorf("-from: %w", err)
+		}
+	}
+	if to != "" {
+		if last, err = forkMgr.ResolveRef(a.bc, to); err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+	}
+	if first > last {
+		return fmt.Errorf("-from #%d is past -to #%d", first, last)
+	}
+
+	fmt.Fprintf(w, "Total blocks: %d\n\n", len(blocks))
+	for _, block := range blocks[first : last+1] {
+		PrintBlock(w, block)
 	}
 	return nil
 }
 
-func (a *App) CmdValidate() error {
+func (a *App) CmdValidate(w io.Writer) error {
 	if err := a.bc.Validate(); err != nil {
 		return fmt.Errorf("validation failed: %w", err)
 	}
-	fmt.Println("✓ Blockchain is valid")
+	fmt.Fprintln(w, "✓ Blockchain is valid")
+	return nil
+}
+
+func (a *App) CmdSearch(w io.Writer, query string) error {
+	results, err := a.Search(query)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Found %d results\n\n", len(results))
+
+	for _, result := range results {
+		fmt.Fprintf(w, "Hit: block #%d, tx #%d\n", result.Block.Index, result.Tx)
+		PrintBlock(w, result.Block)
+	}
+	return nil
+}
+
+// CmdProvenance traces a record from the chain it was issued on through
+// every resolve that replayed it, up to its place in this chain.
+func (a *App) CmdProvenance(w io.Writer, chainName, recordID string) error {
+	var found *blockchain.Block
+	for _, block := range a.bc.Blocks() {
+		if block.Data.ID == recordID {
+			found = block
+			break
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("record %s not found in chain '%s'", recordID, chainName)
+	}
+
+	record := found.Data
+	fmt.Fprintf(w, "Record %s: %s | %s | %s | grade %d\n",
+		record.ID, record.FullName, record.Zachetka, record.Subject, record.Grade)
+
+	issuedAt, origin := record.IssuedAt, record.Origin
+	if issuedAt == 0 {
+		issuedAt = found.Timestamp
+	}
+	if origin == "" {
+		origin = "unknown chain"
+	} else {
+		origin = fmt.Sprintf("'%s'", origin)
+	}
+	fmt.Fprintf(w, "  Issued %s on %s\n", formatTime(issuedAt), origin)
+
+	for i, hop := range record.Provenance {
+		fmt.Fprintf(w, "  %d. block #%d on '%s' (%s) replayed into '%s' at %s\n",
+			i+1, hop.Block, hop.Chain, shortHash(hop.Hash), hop.Into, formatTime(hop.ResolvedAt))
+	}
+	fmt.Fprintf(w, "  Now block #%d on '%s' (%s)\n", found.Index, chainName, shortHash(found.Hash))
+	return nil
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).Format("2006-01-02 15:04:05")
+}
+
+func (a *App) CmdAdd(w io.Writer, record blockchain.StudentRecord) error {
+	fmt.Fprintln(w, "Mining block...")
+
+	_, miningTime, err := a.Add(record)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "✓ Block mined successfully in %v\n", miningTime)
+	return nil
+}
+
+func CmdRepair(w io.Writer, forkMgr *fork.Manager, chainName string) error {
+	report, err := forkMgr.RepairFile(chainName)
+	if err != nil {
+		return fmt.Errorf("repair failed: %w", err)
+	}
+
+	if !report.Repaired() {
+		fmt.Fprintf(w, "✓ %s is intact (%d blocks), nothing to repair\n", report.File, report.Kept)
+		return nil
+	}
+
+	fmt.Fprintf(w, "✗ %v\n", report.Reason)
+	fmt.Fprintf(w, "✓ Kept %d blocks (#0-#%d)\n", report.Kept, report.Kept-1)
+	if len(report.Discarded) > 0 {
+		fmt.Fprintf(w, "  Discarded %d blocks:\n", len(report.Discarded))
+		for _, b := range report.Discarded {
+			fmt.Fprintf(w, "    #%d %s - %s (%s)\n", b.Index, b.Data.FullName, b.Data.Subject, b.Hash)
+		}
+	}
+	fmt.Fprintf(w, "✓ Moved into the block store; backup: %s\n", report.Backup)
+	return nil
+}
+
+func CmdRepairTip(w io.Writer, forkMgr *fork.Manager, chainName string) error {
+	height, dropped, err := forkMgr.RepairTip(chainName)
+	if err != nil {
+		return fmt.Errorf("repair failed: %w", err)
+	}
+	if dropped == 0 {
+		fmt.Fprintln(w, "✓ Chain runs through no quarantined block, nothing to repair")
+		return nil
+	}
+	fmt.Fprintf(w, "✗ %d block(s) above #%d run through a quarantined block\n", dropped, height)
+	fmt.Fprintf(w, "✓ Kept %d blocks (#0-#%d); the dropped blocks stay in the block store\n", height+1, height)
 	return nil
 }
 
-func (a *App) CmdSearch(query string) error {
-	results := a.bc.Search(query)
-	fmt.Printf("Found %d results\n\n", len(results))
+// CmdArchive archives a chain. Blocks that reorgs in the journal keep for
+// -undo stay in the block store.
+func CmdArchive(w io.Writer, forkMgr *fork.Manager, chainName string) error {
+	keep, err := resolve.NewManager(forkMgr).KeptTips()
+	if err != nil {
+		return fmt.Errorf("archive failed: %w", err)
+	}
+	archiveFile, pruned, err := forkMgr.Archive(chainName, keep)
+	if err != nil {
+		return fmt.Errorf("archive failed: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Chain '%s' archived to %s\n", chainName, archiveFile)
+	fmt.Fprintf(w, "  %d block(s) no other chain uses removed from the block store\n", pruned)
+	return nil
+}
+
+func CmdUnarchive(w io.Writer, forkMgr *fork.Manager, chainName string) error {
+	jsonFile, err := forkMgr.Unarchive(chainName)
+	if err != nil {
+		return fmt.Errorf("unarchive failed: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Chain '%s' restored to %s\n", chainName, jsonFile)
+	return nil
+}
+
+func CmdInit(w io.Writer, forkMgr *fork.Manager, chainName string) error {
+	adopted, err := forkMgr.Init(chainName)
+	if err != nil {
+		return fmt.Errorf("init failed: %w", err)
+	}
+
+	file, _ := forkMgr.GetChainFile(chainName)
+	if adopted {
+		fmt.Fprintf(w, "✓ Chain '%s' registered with existing file %s\n", chainName, file)
+	} else {
+		fmt.Fprintf(w, "✓ Chain '%s' created in %s\n", chainName, file)
+	}
+	return nil
+}
+
+func CmdRename(w io.Writer, forkMgr *fork.Manager, oldName, newName string) error {
+	if err := forkMgr.Rename(oldName, newName); err != nil {
+		return fmt.Errorf("rename failed: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Chain '%s' renamed to '%s'\n", oldName, newName)
+	return nil
+}
+
+func CmdDelete(w io.Writer, forkMgr *fork.Manager, chainName string, force bool) error {
+	if err := forkMgr.Delete(chainName, force); err != nil {
+		return fmt.Errorf("delete failed: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Chain '%s' deleted\n", chainName)
+	return nil
+}
+
+func CmdRebase(w io.Writer, resolveMgr *resolve.Manager, chainName, ontoName, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format '%s' (use text or json)", format)
+	}
+
+	result, err := resolveMgr.Rebase(chainName, ontoName)
+	if err != nil {
+		return fmt.Errorf("rebase failed: %w", err)
+	}
 
-	for _, block := range results {
-		PrintBlock(block)
+	if format == "json" {
+		return printJSON(w, result)
 	}
+	result.Print(w)
 	return nil
 }
 
-func (a *App) CmdAdd(record blockchain.StudentRecord) error {
-	fmt.Println("Mining block...")
+func CmdResolvePlan(w io.Writer, resolveMgr *resolve.Manager, chainName, otherName string, opts resolve.Options, format string) error {
+	plan, err := resolveMgr.Plan(chainName, otherName, opts)
+	if err != nil {
+		return err
+	}
 
-	miningTime, err := a.bc.AddBlock(record)
+	switch format {
+	case "text":
+		plan.Print(w)
+	case "json":
+		return printJSON(w, plan)
+	default:
+		return fmt.Errorf("unknown format '%s' (use text or json)", format)
+	}
+	return nil
+}
+
+func CmdResolveAllPlan(w io.Writer, resolveMgr *resolve.Manager, names []string, opts resolve.Options, format string) error {
+	plans, err := resolveMgr.PlanAll(names, opts)
 	if err != nil {
-		return fmt.Errorf("failed to add block: %w", err)
+		return err
 	}
 
-	if err := a.storage.Save(a.bc); err != nil {
-		return fmt.Errorf("failed to save blockchain: %w", err)
+	switch format {
+	case "text":
+		for _, plan := range plans {
+			plan.Print(w)
+			fmt.Fprintln(w)
+		}
+	case "json":
+		return printJSON(w, plans)
+	default:
+		return fmt.Errorf("unknown format '%s' (use text or json)", format)
 	}
+	return nil
+}
+
+func CmdWhere(w io.Writer, forkMgr *fork.Manager, source string) error {
+	fmt.Fprintf(w, "Data directory: %s (%s)\n", forkMgr.Dir(), source)
+	fmt.Fprintf(w, "Config:         %s\n", forkMgr.ConfigFile())
+	fmt.Fprintf(w, "Reorg journal:  %s\n", forkMgr.Path(resolve.JournalFile))
+
+	fmt.Fprintf(w, "Block store:    %s\n", forkMgr.Path(storage.BlockStoreFile))
 
-	fmt.Printf("✓ Block mined successfully in %v\n", miningTime)
+	names := forkMgr.Config.Names()
+	fmt.Fprintf(w, "Chains:         %d\n", len(names))
+	for _, name := range names {
+		info, _ := forkMgr.Config.GetChain(name)
+		if forkMgr.InStore(name) {
+			fmt.Fprintf(w, "  %-14s tip %s\n", name, shortHash(info.Tip))
+			continue
+		}
+		file, _ := forkMgr.GetChainFile(name)
+		if storage.IsArchive(file) {
+			fmt.Fprintf(w, "  %-14s %s\n", name, file)
+			continue
+		}
+		fmt.Fprintf(w, "  %-14s %s (moved into the block store by the next change)\n", name, file)
+	}
+
+	store, err := forkMgr.BlockStore()
+	if err != nil {
+		return err
+	}
+	if bad := store.Quarantined(); len(bad) > 0 {
+		fmt.Fprintf(w, "Quarantined:    %d block(s) with an invalid hash; chains running through them fail to load\n", len(bad))
+		for _, hash := range bad {
+			fmt.Fprintf(w, "  %s\n", hash)
+		}
+	}
 	return nil
 }
 
-func PrintBlock(b *blockchain.Block) {
-	fmt.Printf("========== Block #%d ==========\n", b.Index)
-	fmt.Printf("Timestamp:    %d\n", b.Timestamp)
-	fmt.Printf("ID:           %s\n", b.Data.ID)
-	fmt.Printf("Name:         %s\n", b.Data.FullName)
-	fmt.Printf("Zachetka:     %s\n", b.Data.Zachetka)
-	fmt.Printf("Group:        %s\n", b.Data.Group)
-	fmt.Printf("Subject:      %s\n", b.Data.Subject)
-	fmt.Printf("Course:       %d\n", b.Data.Course)
-	fmt.Printf("Grade:        %d\n", b.Data.Grade)
-	fmt.Printf("Hash:         %s...\n", b.Hash)
-	fmt.Printf("PreviousHash: %s...\n", b.PreviousHash)
-	fmt.Printf("Nonce:        %d\n", b.Nonce)
-	fmt.Println()
+func CmdCheckpoint(w io.Writer, forkMgr *fork.Manager, chainName, at string) error {
+	cp, err := forkMgr.AddCheckpoint(chainName, at)
+	if err != nil {
+		return fmt.Errorf("checkpoint failed: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Block #%d of '%s' (%s) is now final\n", cp.Height, chainName, shortHash(cp.Hash))
+	return nil
+}
+
+func CmdCheckpoints(w io.Writer, forkMgr *fork.Manager, chainName string) error {
+	info, _ := forkMgr.Config.GetChain(chainName)
+	fmt.Fprintf(w, "Checkpoints of '%s': %d\n", chainName, len(info.Checkpoints))
+	for _, cp := range info.Checkpoints {
+		fmt.Fprintf(w, "  #%d %s, set %s\n", cp.Height, shortHash(cp.Hash), formatTime(cp.CreatedAt))
+	}
+	if depth := forkMgr.Config.MaxReorgDepth; depth > 0 {
+		fmt.Fprintf(w, "Maximum reorg depth: %d blocks\n", depth)
+	} else {
+		fmt.Fprintln(w, "Maximum reorg depth: unlimited")
+	}
+	return nil
+}
+
+func (a *App) CmdCheckCheckpoints(w io.Writer, forkMgr *fork.Manager, chainName string) error {
+	if err := forkMgr.CheckCheckpoints(chainName, a.bc); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+	if info, _ := forkMgr.Config.GetChain(chainName); len(info.Checkpoints) > 0 {
+		fmt.Fprintf(w, "✓ All %d checkpoint(s) hold\n", len(info.Checkpoints))
+	}
+	return nil
+}
+
+func CmdMaxReorgDepth(w io.Writer, forkMgr *fork.Manager, depth int) error {
+	if err := forkMgr.SetMaxReorgDepth(depth); err != nil {
+		return err
+	}
+	if depth == 0 {
+		fmt.Fprintln(w, "✓ Reorg depth is no longer limited")
+	} else {
+		fmt.Fprintf(w, "✓ Reorgs may roll back at most %d blocks\n", depth)
+	}
+	return nil
+}
+
+func CmdTag(w io.Writer, forkMgr *fork.Manager, chainName, tagName, at string) error {
+	tag, err := forkMgr.AddTag(tagName, chainName, at)
+	if err != nil {
+		return fmt.Errorf("tag failed: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Tagged block #%d of '%s' (%s) as '%s'\n", tag.Height, chainName, shortHash(tag.Hash), tagName)
+	return nil
+}
+
+func CmdUntag(w io.Writer, forkMgr *fork.Manager, tagName string) error {
+	if err := forkMgr.RemoveTag(tagName); err != nil {
+		return fmt.Errorf("untag failed: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Tag '%s' removed\n", tagName)
+	return nil
+}
+
+func CmdTags(w io.Writer, forkMgr *fork.Manager, format string) error {
+	stray, err := forkMgr.StrayTags()
+	if err != nil {
+		return err
+	}
+	isStray := make(map[string]bool, len(stray))
+	for _, s := range stray {
+		isStray[s.Name] = true
+	}
+
+	switch format {
+	case "text":
+		names := forkMgr.TagNames()
+		fmt.Fprintf(w, "Tags: %d\n", len(names))
+		for _, name := range names {
+			tag := forkMgr.Config.Tags[name]
+			status := ""
+			if isStray[name] {
+				status = " [no longer on the chain]"
+			}
+			fmt.Fprintf(w, "  %-24s %s #%d %s, tagged %s%s\n",
+				name, tag.Chain, tag.Height, shortHash(tag.Hash), formatTime(tag.CreatedAt), status)
+		}
+	case "json":
+		return printJSON(w, struct {
+			Tags  map[string]*fork.Tag `json:"tags"`
+			Stray []*fork.StrayTag     `json:"stray"`
+		}{forkMgr.Config.Tags, stray})
+	default:
+		return fmt.Errorf("unknown format '%s' (use text or json)", format)
+	}
+	return nil
+}
+
+// warnStrayTags follows a command that may rewrite chains and warns about
+// tags it left behind.
+func warnStrayTags(w io.Writer, forkMgr *fork.Manager, err error) error {
+	if err != nil {
+		return err
+	}
+	stray, err := forkMgr.StrayTags()
+	if err != nil {
+		return err
+	}
+	for _, s := range stray {
+		if !s.Found {
+			fmt.Fprintf(w, "⚠ Tag '%s': chain '%s' no longer exists\n", s.Name, s.Tag.Chain)
+			continue
+		}
+		fmt.Fprintf(w, "⚠ Tag '%s' (block #%d, %s) is no longer on chain '%s'\n",
+			s.Name, s.Tag.Height, shortHash(s.Tag.Hash), s.Tag.Chain)
+	}
+	return nil
+}
+
+// warnUnmigrated follows a command and warns about chain files it could not
+// move into the block store.
+func warnUnmigrated(w io.Writer, forkMgr *fork.Manager) {
+	unmigrated := forkMgr.Unmigrated()
+	for _, name := range forkMgr.Config.Names() {
+		if err := unmigrated[name]; err != nil {
+			info, _ := forkMgr.Config.GetChain(name)
+			fmt.Fprintf(w, "⚠ Chain '%s' stays in %s: %v (see -validate and -repair)\n",
+				name, forkMgr.Path(info.File), err)
+		}
+	}
+}
+
+func CmdBlock(w io.Writer, forkMgr *fork.Manager, prefix string) error {
+	block, err := forkMgr.FindBlock(prefix)
+	if err != nil {
+		return err
+	}
+	refs, err := forkMgr.RefsContaining(block)
+	if err != nil {
+		return err
+	}
+
+	PrintBlock(w, block)
+	if len(refs) == 0 {
+		fmt.Fprintln(w, "Chains: none (orphaned)")
+	} else {
+		fmt.Fprintf(w, "Chains: %s\n", strings.Join(refs, ", "))
+	}
+	return nil
+}
+
+func CmdReorgs(w io.Writer, resolveMgr *resolve.Manager, format string) error {
+	journal, err := resolveMgr.Reorgs()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "text":
+		journal.Print(w)
+	case "json":
+		return printJSON(w, journal)
+	default:
+		return fmt.Errorf("unknown format '%s' (use text or json)", format)
+	}
+	return nil
+}
+
+func CmdUndoResolve(w io.Writer, resolveMgr *resolve.Manager, id int) error {
+	if err := resolveMgr.Undo(id); err != nil {
+		return fmt.Errorf("undo failed: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Reorg #%d undone; its chains are back to their state before the resolve\n", id)
+	return nil
+}
+
+func CmdDiff(w io.Writer, resolveMgr *resolve.Manager, chainName, otherName, format string) error {
+	diff, err := resolveMgr.Diff(chainName, otherName)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "text":
+		diff.WriteText(w)
+	case "unified":
+		diff.WriteUnified(w)
+	case "json":
+		return printJSON(w, diff)
+	default:
+		return fmt.Errorf("unknown format '%s' (use text, unified or json)", format)
+	}
+	return nil
+}
+
+func CmdForks(w io.Writer, forkMgr *fork.Manager, format string) error {
+	roots := forkMgr.Tree()
+
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "Chains: %d\n\n", len(forkMgr.Config.Chains))
+		fork.PrintTree(w, roots)
+	case "dot":
+		fork.WriteDOT(w, roots)
+	case "json":
+		return printJSON(w, roots)
+	default:
+		return fmt.Errorf("unknown format '%s' (use text, dot or json)", format)
+	}
+	return nil
+}
+
+func printJSON(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(data))
+	return nil
+}
+
+func PrintBlock(w io.Writer, b *blockchain.Block) {
+	fmt.Fprintf(w, "========== Block #%d ==========\n", b.Index)
+	fmt.Fprintf(w, "Timestamp:    %d\n", b.Timestamp)
+	fmt.Fprintf(w, "ID:           %s\n", b.Data.ID)
+	fmt.Fprintf(w, "Name:         %s\n", b.Data.FullName)
+	fmt.Fprintf(w, "Zachetka:     %s\n", b.Data.Zachetka)
+	fmt.Fprintf(w, "Group:        %s\n", b.Data.Group)
+	fmt.Fprintf(w, "Subject:      %s\n", b.Data.Subject)
+	fmt.Fprintf(w, "Course:       %d\n", b.Data.Course)
+	fmt.Fprintf(w, "Grade:        %d\n", b.Data.Grade)
+	if b.Data.IssuedAt != 0 && b.Data.IssuedAt != b.Timestamp {
+		fmt.Fprintf(w, "Issued at:    %d\n", b.Data.IssuedAt)
+	}
+	if n := len(b.Data.Provenance); n > 0 {
+		fmt.Fprintf(w, "Replayed:     %d time(s), last from '%s'\n", n, b.Data.Provenance[n-1].Chain)
+	}
+	fmt.Fprintf(w, "Hash:         %s...\n", b.Hash)
+	fmt.Fprintf(w, "PreviousHash: %s...\n", b.PreviousHash)
+	fmt.Fprintf(w, "Nonce:        %d\n", b.Nonce)
+	fmt.Fprintln(w)
+}
+
+func shortHash(h string) string {
+	if len(h) > 16 {
+		return h[:16]
+	}
+	return h
 }
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// EnvDataDir names the environment variable that sets the data directory.
+const EnvDataDir = "BC_DATADIR"
+
+// DataDir picks the data directory: the -datadir flag, then BC_DATADIR, then
+// lab-bc under the user's config directory. It returns an absolute path and
+// where the choice came from.
+func DataDir(flagValue string) (string, string, error) {
+	dir, source := flagValue, "-datadir"
+	if dir == "" {
+		dir, source = os.Getenv(EnvDataDir), EnvDataDir
+	}
+	if dir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", "", fmt.Errorf("no data directory: set -datadir or %s (%w)", EnvDataDir, err)
+		}
+		dir, source = filepath.Join(configDir, "lab-bc"), "default"
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return abs, source, nil
+}
@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/fork"
+	"github.com/rx3lixir/lab_bc/internal/resolve"
+)
+
+const (
+	// WatchInterval is how often the server looks for changes made to the
+	// data directory by other bc commands.
+	WatchInterval = time.Second
+	// KeepAliveInterval is how often an idle event stream gets a comment
+	// line, so proxies do not close it.
+	KeepAliveInterval = 15 * time.Second
+)
+
+// watched is what the server last knew of the data directory: the tip of
+// every chain, the last reorg in the journal and the tips that failed
+// validation.
+type watched struct {
+	tips    map[string]string
+	reorg   int
+	invalid map[string]string
+}
+
+// watch publishes what other bc commands change in the data directory.
+func (s *Server) watch() {
+	for range time.Tick(WatchInterval) {
+		s.mu.Lock()
+		if err := s.sync(true); err != nil {
+			s.log.Printf("cannot watch data directory: %v", err)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// sync compares the data directory with what the server last knew of it and,
+// if publish is set, publishes the differences: new reorgs in the journal as
+// EventChainResolved, new blocks as EventBlockAdded and new tips that fail
+// validation as EventValidationFailed. The server's own changes are published
+// as they are made, so handlers sync without publishing afterwards. Nothing
+// changed while the manager was not reloaded. Callers hold s.mu.
+func (s *Server) sync(publish bool) error {
+	forkMgr, err := s.manager()
+	if err != nil || forkMgr == s.synced {
+		return err
+	}
+	j, err := resolve.NewManager(forkMgr).Reorgs()
+	if err != nil {
+		return err
+	}
+
+	tips := make(map[string]string)
+	for _, r := range j.Reorgs {
+		if r.ID <= s.seen.reorg {
+			continue
+		}
+		s.seen.reorg = r.ID
+		if !publish || r.UndoneAt != 0 {
+			continue
+		}
+		tip, err := forkMgr.FindBlock(r.ResultTip)
+		if err != nil {
+			return err
+		}
+		for _, e := range r.Events(tip) {
+			// A chain the resolve rewrote is only announced again once
+			// something is added on top of the result.
+			tips[e.Chain] = r.ResultTip
+			s.bus.Publish(e)
+		}
+	}
+
+	for _, name := range forkMgr.Config.Names() {
+		info, _ := forkMgr.Config.GetChain(name)
+		if info.Tip == "" && info.File == "" {
+			continue
+		}
+		store, err := forkMgr.Storage(name)
+		if err != nil {
+			return err
+		}
+		bc, err := store.Load()
+		if err != nil || bc == nil {
+			continue
+		}
+		tip := bc.Blocks()[bc.Length()-1].Hash
+		old, known := s.seen.tips[name]
+		s.seen.tips[name] = tip
+		if !publish || tip == old {
+			continue
+		}
+
+		if err := bc.Validate(); err != nil {
+			if s.seen.invalid[name] != tip {
+				s.seen.invalid[name] = tip
+				s.bus.Publish(blockchain.Event{
+					Type:   blockchain.EventValidationFailed,
+					Chain:  name,
+					Height: bc.Length() - 1,
+					Error:  fmt.Sprintf("chain '%s' validation failed: %v", name, err),
+				})
+			}
+			continue
+		}
+		delete(s.seen.invalid, name)
+
+		if !known || tips[name] == tip {
+			continue
+		}
+		from := tips[name]
+		if from == "" {
+			from = old
+		}
+		for _, block := range bc.Blocks()[addedAfter(forkMgr, bc, from):] {
+			s.bus.Publish(blockchain.Event{Type: blockchain.EventBlockAdded, Chain: name, Height: block.Index, Block: block})
+		}
+	}
+	s.synced = forkMgr
+	return nil
+}
+
+// addedAfter returns the height of the first block of bc that was not part of
+// the chain ending at old.
+func addedAfter(forkMgr *fork.Manager, bc *blockchain.Blockchain, old string) int {
+	if height, ok := bc.HeightOf(old); ok {
+		return height + 1
+	}
+	previous, err := forkMgr.ChainAt(old)
+	if err != nil || previous == nil {
+		return bc.Length() - 1
+	}
+	return forkMgr.FindCommonAncestor(previous, bc) + 1
+}
+
+// getEvents streams the events of one chain, main by default, as
+// server-sent events. The id of every event is the chain's height after it,
+// so a client reconnecting with Last-Event-ID first gets EventBlockAdded for
+// every block above that height.
+func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	name := chainParam(r)
+	last := -1
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		height, err := strconv.Atoi(id)
+		if err != nil || height < -1 {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID '%s'", id), http.StatusBadRequest)
+			return
+		}
+		last = height
+	}
+
+	// Subscribing before the chain is read means no block falls between
+	// the replay and the live events; blocks in both are sent once.
+	events, unsubscribe := s.bus.Subscribe()
+	defer unsubscribe()
+
+	var missed []*blockchain.Block
+	s.mu.Lock()
+	err := func() error {
+		forkMgr, err := s.manager()
+		if err != nil {
+			return err
+		}
+		app, err := s.open(forkMgr, name)
+		if err != nil {
+			return err
+		}
+		if last >= 0 && last+1 < app.bc.Length() {
+			missed = app.bc.Blocks()[last+1:]
+		}
+		return nil
+	}()
+	s.mu.Unlock()
+	if err != nil {
+		status := http.StatusInternalServerError
+		var he *httpError
+		if errors.As(err, &he) {
+			status = he.status
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, block := range missed {
+		writeEvent(w, blockchain.Event{
+			Type:   blockchain.EventBlockAdded,
+			Chain:  name,
+			Height: block.Index,
+			Time:   block.Timestamp,
+			Block:  block,
+		})
+		last = block.Index
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.Chain != name || (e.Type == blockchain.EventBlockAdded && e.Height <= last) {
+				continue
+			}
+			writeEvent(w, e)
+			last = e.Height
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e blockchain.Event) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Height, e.Type, data)
+}
@@ -3,7 +3,10 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
 	"github.com/rx3lixir/lab_bc/internal/fork"
@@ -15,43 +18,203 @@ const ConfigFile = "fork_config.json"
 
 func Run() error {
 	if len(os.Args) < 2 {
-		printUsage()
+		printUsage(os.Stdout)
 		return nil
 	}
 
-	chainName := os.Args[1]
+	switch os.Args[1] {
+	case "node":
+		return runNode(os.Args[2:])
+	case "serve":
+		return runServe(os.Args[2:])
+	}
+
+	if url, cmdline, ok := rpcFlag(os.Args[1:]); ok {
+		return runRemote(url, cmdline)
+	}
+	return run(os.Args[1:], "", flag.ExitOnError, os.Stdout, os.Stderr)
+}
+
+// newResolveManager returns a resolve.Manager reporting to w.
+func newResolveManager(forkMgr *fork.Manager, w io.Writer) *resolve.Manager {
+	resolveMgr := resolve.NewManager(forkMgr)
+	resolveMgr.SetOutput(w)
+	return resolveMgr
+}
+
+// run carries out one command line. A configFile other than "" takes the
+// place of the data directory the command line names, for command lines run
+// by bc serve on behalf of -rpc clients.
+func run(cmdline []string, configFile string, errorHandling flag.ErrorHandling, stdout, stderr io.Writer) error {
+	// A leading flag means a command that does not work on a single chain.
+	chainName, args := cmdline[0], cmdline[1:]
+	if strings.HasPrefix(chainName, "-") {
+		chainName, args = "", cmdline
+	}
+
+	fs := flag.NewFlagSet("bc", errorHandling)
+	fs.SetOutput(stderr)
 
-	listFlag := flag.Bool("list", false, "List all blocks")
-	validateFlag := flag.Bool("validate", false, "Validate blockchain(s)")
-	searchFlag := flag.String("search", "", "Search keyword")
-	addFlag := flag.Bool("add", false, "Add new record")
-	forkFlag := flag.String("fork", "", "Create fork from current chain")
-	resolveFlag := flag.String("resolve", "", "Resolve fork conflict with another chain")
+	listFlag := fs.Bool("list", false, "List all blocks")
+	validateFlag := fs.Bool("validate", false, "Validate blockchain(s)")
+	searchFlag := fs.String("search", "", "Search words (substrings) or field:value")
+	provenanceFlag := fs.String("provenance", "", "Trace a record through every resolve it went through")
+	addFlag := fs.Bool("add", false, "Add new record")
+	forkFlag := fs.String("fork", "", "Create fork from current chain")
+	atFlag := fs.String("at", "", "Fork point: block height or hash prefix (default: tip)")
+	resolveFlag := fs.String("resolve", "", "Resolve fork conflict with another chain")
+	resolveAllFlag := fs.Bool("resolve-all", false, "Resolve all chains given as arguments at once")
+	reorgsFlag := fs.Bool("reorgs", false, "List past resolves from the reorg journal")
+	undoResolveFlag := fs.Int("undo-resolve", 0, "Restore chains to their state before reorg <id>")
+	blockFlag := fs.String("block", "", "Show any stored block by hash prefix, orphaned or not")
+	checkpointFlag := fs.Bool("checkpoint", false, "Make a block of the chain final (height, hash or tag argument; default: tip)")
+	checkpointsFlag := fs.Bool("checkpoints", false, "List the chain's checkpoints")
+	maxReorgDepthFlag := fs.Int("max-reorg-depth", -1, "Set how many blocks a reorg may roll back (0: no limit)")
+	tagFlag := fs.String("tag", "", "Tag a block of the chain (height, hash or tag argument; default: tip)")
+	untagFlag := fs.String("untag", "", "Remove a tag")
+	tagsFlag := fs.Bool("tags", false, "List tags and whether their blocks are still on their chains")
+	fromFlag := fs.String("from", "", "First block for -list: height, hash prefix or tag")
+	toFlag := fs.String("to", "", "Last block for -list: height, hash prefix or tag")
+	rebaseFlag := fs.String("rebase", "", "Replay chain's own records onto another chain's tip")
+	diffFlag := fs.String("diff", "", "Compare chain block by block with another chain")
+	dryRunFlag := fs.Bool("dry-run", false, "Show the resolve plan without writing anything")
+	policyFlag := fs.String("policy", "winner", "Conflicting grades: winner, latest, authority or manual")
+	authorityFlag := fs.String("authority", "", "Authoritative chain for -policy authority")
+	repairFlag := fs.Bool("repair", false, "Truncate chain to its last valid block")
+	archiveFlag := fs.Bool("archive", false, "Move chain into a read-only archive")
+	unarchiveFlag := fs.Bool("unarchive", false, "Restore chain from its archive")
+	verifyFlag := fs.Bool("verify", false, "Validate chain when loading it")
+	forksFlag := fs.Bool("forks", false, "Show the fork tree of all chains")
+	initFlag := fs.Bool("init", false, "Create a new chain")
+	renameFlag := fs.String("rename", "", "Rename chain")
+	deleteFlag := fs.Bool("delete", false, "Delete chain and its file")
+	forceFlag := fs.Bool("force", false, "Delete even if other chains fork from this one")
+	dataDirFlag := fs.String("datadir", "", "Data directory (default: $"+EnvDataDir+" or the user config dir)")
+	whereFlag := fs.Bool("where", false, "Print the data directory and file paths in use")
+	formatFlag := fs.String("format", "text", "Output format: text, dot, unified or json")
 
-	name := flag.String("name", "", "Student name")
-	course := flag.Int("course", 0, "Course number")
-	group := flag.String("group", "", "Group name")
-	zachetka := flag.String("zachetka", "", "Zachetka number")
-	subject := flag.String("subject", "", "Subject name")
-	grade := flag.Int("grade", 0, "Grade (2-5)")
+	name := fs.String("name", "", "Student name")
+	course := fs.Int("course", 0, "Course number")
+	group := fs.String("group", "", "Group name")
+	zachetka := fs.String("zachetka", "", "Zachetka number")
+	subject := fs.String("subject", "", "Subject name")
+	grade := fs.Int("grade", 0, "Grade (2-5)")
 
-	flag.CommandLine.Parse(os.Args[2:])
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	dataDir, source := filepath.Dir(configFile), "bc serve"
+	if configFile == "" {
+		var err error
+		if dataDir, source, err = DataDir(*dataDirFlag); err != nil {
+			return err
+		}
+		configFile = filepath.Join(dataDir, ConfigFile)
+	}
 
-	forkMgr, err := fork.NewManager(ConfigFile)
+	forkMgr, err := fork.NewManager(configFile)
 	if err != nil {
 		return fmt.Errorf("failed to initialize fork manager: %w", err)
 	}
+	defer warnUnmigrated(stderr, forkMgr)
 
-	if err := forkMgr.RegisterChain(chainName); err != nil {
-		return fmt.Errorf("failed to register chain: %w", err)
+	if *whereFlag {
+		return CmdWhere(stdout, forkMgr, source)
+	}
+
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	if chainName == "" {
+		switch {
+		case *forksFlag:
+			return CmdForks(stdout, forkMgr, *formatFlag)
+
+		case *blockFlag != "":
+			return CmdBlock(stdout, forkMgr, *blockFlag)
+
+		case *reorgsFlag:
+			return CmdReorgs(stdout, newResolveManager(forkMgr, stdout), *formatFlag)
+
+		case *undoResolveFlag != 0:
+			return warnStrayTags(stderr, forkMgr, CmdUndoResolve(stdout, newResolveManager(forkMgr, stdout), *undoResolveFlag))
+
+		case *maxReorgDepthFlag >= 0:
+			return CmdMaxReorgDepth(stdout, forkMgr, *maxReorgDepthFlag)
+
+		case *tagsFlag:
+			return CmdTags(stdout, forkMgr, *formatFlag)
+
+		case *untagFlag != "":
+			return CmdUntag(stdout, forkMgr, *untagFlag)
+
+		case *resolveAllFlag:
+			// Parsing stops at the first chain name, so a flag after it
+			// would be taken for a chain.
+			for _, arg := range fs.Args() {
+				if strings.HasPrefix(arg, "-") {
+					return fmt.Errorf("'%s' comes after the chain names; put flags before them: bc -resolve-all [flags] <chain>...", arg)
+				}
+			}
+			policy, err := resolve.ParsePolicy(*policyFlag)
+			if err != nil {
+				return err
+			}
+			opts := resolve.Options{Policy: policy, Authority: *authorityFlag}
+
+			resolveMgr := newResolveManager(forkMgr, stdout)
+			if *dryRunFlag {
+				return CmdResolveAllPlan(stdout, resolveMgr, fs.Args(), opts, *formatFlag)
+			}
+			return warnStrayTags(stderr, forkMgr, resolveMgr.ResolveAll(fs.Args(), opts))
+
+		default:
+			printUsage(stdout)
+			return nil
+		}
+	}
+
+	if *initFlag {
+		return CmdInit(stdout, forkMgr, chainName)
 	}
 
 	chainFile, err := forkMgr.GetChainFile(chainName)
+	if err != nil {
+		return fmt.Errorf("unknown chain '%s' (use -init to create it, -forks to list chains)", chainName)
+	}
+
+	switch {
+	case *archiveFlag:
+		return CmdArchive(stdout, forkMgr, chainName)
+
+	case *unarchiveFlag:
+		return CmdUnarchive(stdout, forkMgr, chainName)
+
+	case *renameFlag != "":
+		return CmdRename(stdout, forkMgr, chainName, *renameFlag)
+
+	case *deleteFlag:
+		return warnStrayTags(stderr, forkMgr, CmdDelete(stdout, forkMgr, chainName, *forceFlag))
+	}
+
+	if *repairFlag {
+		if storage.IsArchive(chainFile) {
+			return fmt.Errorf("chain '%s' is archived; unarchive it before repairing", chainName)
+		}
+		if forkMgr.InStore(chainName) {
+			return CmdRepairTip(stdout, forkMgr, chainName)
+		}
+		return CmdRepair(stdout, forkMgr, chainName)
+	}
+
+	store, err := forkMgr.Storage(chainName)
 	if err != nil {
 		return err
 	}
+	store.SetVerify(*verifyFlag)
 
-	store := storage.NewJSONStorage(chainFile)
 	app, err := NewApp(store)
 	if err != nil {
 		return fmt.Errorf("failed to initialize app: %w", err)
@@ -59,25 +222,63 @@ func Run() error {
 
 	switch {
 	case *listFlag:
-		return app.CmdList()
+		return app.CmdList(stdout, forkMgr, *fromFlag, *toFlag)
 
 	case *validateFlag != false:
-		if flag.NArg() > 0 {
-			otherChain := flag.Arg(0)
-			resolveMgr := resolve.NewManager(forkMgr)
+		if fs.NArg() > 0 {
+			otherChain := fs.Arg(0)
+			resolveMgr := newResolveManager(forkMgr, stdout)
 			return resolveMgr.Validate(chainName, otherChain)
 		}
-		return app.CmdValidate()
+		if err := app.CmdValidate(stdout); err != nil {
+			return err
+		}
+		return app.CmdCheckCheckpoints(stdout, forkMgr, chainName)
 
 	case *searchFlag != "":
-		return app.CmdSearch(*searchFlag)
+		return app.CmdSearch(stdout, *searchFlag)
+
+	case *provenanceFlag != "":
+		return app.CmdProvenance(stdout, chainName, *provenanceFlag)
 
 	case *forkFlag != "":
-		return forkMgr.CreateFork(chainName, *forkFlag)
+		return forkMgr.CreateFork(chainName, *forkFlag, *atFlag)
+
+	case *diffFlag != "":
+		return CmdDiff(stdout, newResolveManager(forkMgr, stdout), chainName, *diffFlag, *formatFlag)
+
+	case *rebaseFlag != "":
+		return warnStrayTags(stderr, forkMgr, CmdRebase(stdout, newResolveManager(forkMgr, stdout), chainName, *rebaseFlag, *formatFlag))
+
+	case *checkpointFlag:
+		at := *atFlag
+		if fs.NArg() > 0 {
+			at = fs.Arg(0)
+		}
+		return CmdCheckpoint(stdout, forkMgr, chainName, at)
+
+	case *checkpointsFlag:
+		return CmdCheckpoints(stdout, forkMgr, chainName)
+
+	case *tagFlag != "":
+		at := *atFlag
+		if fs.NArg() > 0 {
+			at = fs.Arg(0)
+		}
+		return CmdTag(stdout, forkMgr, chainName, *tagFlag, at)
 
 	case *resolveFlag != "":
-		resolveMgr := resolve.NewManager(forkMgr)
-		return resolveMgr.Resolve(chainName, *resolveFlag)
+		policy, err := resolve.ParsePolicy(*policyFlag)
+		if err != nil {
+			return err
+		}
+		opts := resolve.Options{Policy: policy, Authority: *authorityFlag}
+
+		resolveMgr := newResolveManager(forkMgr, stdout)
+		if *dryRunFlag {
+			return CmdResolvePlan(stdout, resolveMgr, chainName, *resolveFlag, opts, *formatFlag)
+		}
+		return warnStrayTags(stderr, forkMgr, resolveMgr.Resolve(chainName, *resolveFlag, opts))
 
 	case *addFlag:
 		record := blockchain.StudentRecord{
@@ -87,38 +288,127 @@ func Run() error {
 			Subject:  *subject,
 			Course:   *course,
 			Grade:    *grade,
+			Origin:   chainName,
 		}
-		return app.CmdAdd(record)
+		return app.CmdAdd(stdout, record)
 
 	default:
-		printUsage()
+		printUsage(stdout)
 		return nil
 	}
 }
 
-func printUsage() {
-	fmt.Println("Usage: bc <chain_name> <command> [options]")
-	fmt.Println()
-	fmt.Println("Commands:")
-	fmt.Println("  -list                    List all blocks in chain")
-	fmt.Println("  -validate [other_chain]  Validate chain(s)")
-	fmt.Println("  -search <keyword>        Search for keyword")
-	fmt.Println("  -add                     Add new record")
-	fmt.Println("  -fork <target_name>      Create fork from current chain")
-	fmt.Println("  -resolve <other_chain>   Resolve fork conflict")
-	fmt.Println()
-	fmt.Println("Options for -add:")
-	fmt.Println("  -name <string>      Student full name")
-	fmt.Println("  -course <int>       Course number")
-	fmt.Println("  -group <string>     Group name")
-	fmt.Println("  -zachetka <string>  Zachetka number")
-	fmt.Println("  -subject <string>   Subject name")
-	fmt.Println("  -grade <int>        Grade (2-5)")
-	fmt.Println()
-	fmt.Println("Examples:")
-	fmt.Println("  bc main -add -name \"Иванов И.И.\" -grade 5 -course 5 -group \"5.507M\" -zachetka \"202434\" -subject \"Математика\"")
-	fmt.Println("  bc main -fork branch_a")
-	fmt.Println("  bc branch_a -add -name \"Петров П.П.\" -grade 4 -course 5 -group \"5.507M\" -zachetka \"202435\" -subject \"Физика\"")
-	fmt.Println("  bc main -validate branch_a")
-	fmt.Println("  bc main -resolve branch_a")
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: bc <chain_name> <command> [options]")
+	fmt.Fprintln(w, "       bc <global_command> [options]")
+	fmt.Fprintln(w, "       bc node [-listen <addr>] [-peers <file>] [-chain <name>]")
+	fmt.Fprintln(w, "       bc serve [-addr <addr>]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  -init                    Create a new chain")
+	fmt.Fprintln(w, "  -rename <new_name>       Rename chain (its forks follow)")
+	fmt.Fprintln(w, "  -delete [-force]         Delete chain; -force re-parents its forks")
+	fmt.Fprintln(w, "  -list                    List all blocks in chain")
+	fmt.Fprintln(w, "    -from <ref> -to <ref>  Only blocks in this range (height, hash prefix or tag)")
+	fmt.Fprintln(w, "  -validate [other_chain]  Validate chain(s)")
+	fmt.Fprintln(w, "  -search <query>          Search records with a word containing each word of")
+	fmt.Fprintln(w, "                           query, or matching name|zachetka|group|subject:value exactly")
+	fmt.Fprintln(w, "  -provenance <record_id>  Show where a record was issued and every resolve it went through")
+	fmt.Fprintln(w, "  -add                     Add new record")
+	fmt.Fprintln(w, "  -fork <target_name>      Create fork from current chain")
+	fmt.Fprintln(w, "    -at <height|hash>      Fork from an earlier block instead of the tip")
+	fmt.Fprintln(w, "  -diff <other_chain>      Compare divergent blocks (-format text|unified|json)")
+	fmt.Fprintln(w, "  -resolve <other_chain>   Resolve fork conflict")
+	fmt.Fprintln(w, "    -dry-run               Only print the plan (-format text|json)")
+	fmt.Fprintln(w, "    -policy <p>            Conflicting grades: winner (default), latest,")
+	fmt.Fprintln(w, "                           authority [-authority <chain>] or manual")
+	fmt.Fprintln(w, "  -checkpoint [ref]        Make the tip, or the block ref, final: no reorg may roll it back")
+	fmt.Fprintln(w, "  -checkpoints             List the chain's checkpoints")
+	fmt.Fprintln(w, "  -tag <name> [ref]        Tag the tip, or the block ref (height, hash prefix or tag)")
+	fmt.Fprintln(w, "  -rebase <other_chain>    Re-mine this chain's own records on top of the other")
+	fmt.Fprintln(w, "                           chain's tip (-format text|json)")
+	fmt.Fprintln(w, "  -repair                  Truncate chain to last valid block (keeps what it drops)")
+	fmt.Fprintln(w, "  -archive                 Move chain into a read-only .gz archive, dropping")
+	fmt.Fprintln(w, "                           blocks no other chain uses from the block store")
+	fmt.Fprintln(w, "  -unarchive               Restore chain from its .gz archive")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global commands:")
+	fmt.Fprintln(w, "  -forks [-format text|dot|json]  Show the fork tree of all chains")
+	fmt.Fprintln(w, "  -resolve-all [options] <chain> <chain>...  Resolve many chains at once")
+	fmt.Fprintln(w, "                           (takes -dry-run, -policy, -authority)")
+	fmt.Fprintln(w, "  -reorgs [-format text|json]  List past resolves and what they orphaned")
+	fmt.Fprintln(w, "  -undo-resolve <id>       Restore the chains of a resolve to their prior state")
+	fmt.Fprintln(w, "  -tags [-format text|json]  List tags; flags those no longer on their chain")
+	fmt.Fprintln(w, "  -untag <name>            Remove a tag")
+	fmt.Fprintln(w, "  -max-reorg-depth <n>     Refuse reorgs rolling back more than n blocks (0: no limit)")
+	fmt.Fprintln(w, "  -block <hash>            Show a stored block and the chains that contain it")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "  -where                   Print the data directory and file paths in use")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Node:")
+	fmt.Fprintln(w, "  bc node shares a chain with peers over TCP: it announces new blocks,")
+	fmt.Fprintln(w, "  fetches and validates the blocks peers announce, and switches to a")
+	fmt.Fprintln(w, "  peer's chain when it is longer (equal length: lower tip hash).")
+	fmt.Fprintln(w, "  -listen <addr>           Address to accept peers on (default :9000)")
+	fmt.Fprintln(w, "  -peers <file>            Peer addresses, one per line")
+	fmt.Fprintln(w, "  -chain <name>            Chain to share (default main)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Serve:")
+	fmt.Fprintln(w, "  bc serve runs an HTTP API over the data directory:")
+	fmt.Fprintln(w, "    GET  /chains")
+	fmt.Fprintln(w, "    GET  /chains/{name}/blocks?from=<ref>&to=<ref>")
+	fmt.Fprintln(w, "    POST /chains/{name}/records[?mode=queue]  (JSON record; mined unless queued)")
+	fmt.Fprintln(w, "    GET  /blocks/{hash}")
+	fmt.Fprintln(w, "    GET  /blocks/{i}/merkle-proof/{tx}[?chain=<name>]  (a block holds one record,")
+	fmt.Fprintln(w, "         so tx is 0 and the proof is the record hash paired with itself)")
+	fmt.Fprintln(w, "    GET  /search?q=<query>[&chain=<name>]")
+	fmt.Fprintln(w, "    GET  /events[?chain=<name>]  (server-sent BlockAdded, ChainResolved and")
+	fmt.Fprintln(w, "         ValidationFailed; Last-Event-ID resumes after that block height)")
+	fmt.Fprintln(w, "    POST /rpc                JSON-RPC 2.0: add, list, validate, search, fork,")
+	fmt.Fprintln(w, "                             resolve, merkle_proof, exec")
+	fmt.Fprintln(w, "         (bc serve has no authentication: exec runs -delete, -rename,")
+	fmt.Fprintln(w, "         -resolve-all, -undo-resolve, -rebase, -repair, -archive, -unarchive,")
+	fmt.Fprintln(w, "         -untag and -max-reorg-depth only for clients on 127.0.0.1 or ::1)")
+	fmt.Fprintln(w, "  -addr <addr>             Address to serve on (default :8080)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global options:")
+	fmt.Fprintln(w, "  -datadir <dir>           Data directory (default: $BC_DATADIR, else lab-bc")
+	fmt.Fprintln(w, "                           under the user config dir)")
+	fmt.Fprintln(w, "  -verify                  Validate chain when loading it")
+	fmt.Fprintln(w, "  -rpc <url>               Run the command on the bc serve at url instead of")
+	fmt.Fprintln(w, "                           on local files (its data directory; -datadir is ignored;")
+	fmt.Fprintln(w, "                           commands that delete or rewrite chains need a local serve)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Options for -add:")
+	fmt.Fprintln(w, "  -name <string>      Student full name")
+	fmt.Fprintln(w, "  -course <int>       Course number")
+	fmt.Fprintln(w, "  -group <string>     Group name")
+	fmt.Fprintln(w, "  -zachetka <string>  Zachetka number")
+	fmt.Fprintln(w, "  -subject <string>   Subject name")
+	fmt.Fprintln(w, "  -grade <int>        Grade (2-5)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Examples:")
+	fmt.Fprintln(w, "  bc main -init")
+	fmt.Fprintln(w, "  bc main -add -name \"Иванов И.И.\" -grade 5 -course 5 -group \"5.507M\" -zachetka \"202434\" -subject \"Математика\"")
+	fmt.Fprintln(w, "  bc main -fork branch_a")
+	fmt.Fprintln(w, "  bc main -fork branch_b -at 3")
+	fmt.Fprintln(w, "  bc branch_a -add -name \"Петров П.П.\" -grade 4 -course 5 -group \"5.507M\" -zachetka \"202435\" -subject \"Физика\"")
+	fmt.Fprintln(w, "  bc main -validate branch_a")
+	fmt.Fprintln(w, "  bc main -diff branch_a -format unified")
+	fmt.Fprintln(w, "  bc main -resolve branch_a -dry-run")
+	fmt.Fprintln(w, "  bc main -resolve branch_a")
+	fmt.Fprintln(w, "  bc main -resolve branch_a -policy manual")
+	fmt.Fprintln(w, "  bc main -tag session-2025-autumn 12")
+	fmt.Fprintln(w, "  bc main -list -from session-2025-autumn")
+	fmt.Fprintln(w, "  bc main -diff session-2025-autumn")
+	fmt.Fprintln(w, "  bc main -checkpoint session-2025-autumn")
+	fmt.Fprintln(w, "  bc branch_a -rebase main")
+	fmt.Fprintln(w, "  bc main -repair")
+	fmt.Fprintln(w, "  bc -forks -format dot | dot -Tpng -o forks.png")
+	fmt.Fprintln(w, "  bc -resolve-all -policy latest main branch_a branch_b")
+	fmt.Fprintln(w, "  bc -undo-resolve 3")
+	fmt.Fprintln(w, "  bc -block 00a3f1")
+	fmt.Fprintln(w, "  bc node -listen :9001 -peers peers.txt -datadir ./node1")
+	fmt.Fprintln(w, "  bc year2024 -archive")
+	fmt.Fprintln(w, "  bc year2024 -list")
 }
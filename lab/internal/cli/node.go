@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/rx3lixir/lab_bc/internal/p2p"
+)
+
+func runNode(args []string) error {
+	fs := flag.NewFlagSet("node", flag.ExitOnError)
+	listen := fs.String("listen", ":9000", "Address to accept peers on")
+	peersFile := fs.String("peers", "", "File with peer addresses, one per line")
+	chain := fs.String("chain", "main", "Chain to share with peers")
+	dataDirFlag := fs.String("datadir", "", "Data directory (default: $"+EnvDataDir+" or the user config dir)")
+
+	fs.Parse(args)
+
+	dataDir, _, err := DataDir(*dataDirFlag)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	var peers []string
+	if *peersFile != "" {
+		if peers, err = p2p.LoadPeers(*peersFile); err != nil {
+			return fmt.Errorf("failed to read peers: %w", err)
+		}
+	}
+
+	node := p2p.NewNode(*chain, *listen, peers, filepath.Join(dataDir, ConfigFile))
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		node.Close()
+	}()
+
+	return node.Run()
+}
@@ -0,0 +1,565 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/resolve"
+)
+
+// JSON-RPC 2.0 error codes. Errors of the chains themselves, like a failed
+// resolve, are codeServerError.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeServerError    = -32000
+)
+
+// MaxRPCRequestSize bounds the body of a JSON-RPC request.
+const MaxRPCRequestSize = 32 << 20
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// rpcResponse is either a result or an error: a successful call always
+// has a result member, null included, and a failed one never has.
+type rpcResponse struct {
+	JSONRPC string
+	Result  any
+	Error   *RPCError
+	ID      json.RawMessage
+}
+
+func (r *rpcResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *RPCError       `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{r.JSONRPC, r.Error, r.ID})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  any             `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{r.JSONRPC, r.Result, r.ID})
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// ExecResult is the result of the exec method: what a bc command line
+// printed on the server, and how it failed, if it did.
+type ExecResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Error  string `json:"error,omitempty"`
+}
+
+// rpcMethods maps method names to their implementations. Every method takes
+// its params as an object; a missing chain means main. local tells whether
+// the call came from this machine, which exec needs for some flags.
+func (s *Server) rpcMethods(local bool) map[string]func(params json.RawMessage) (any, error) {
+	return map[string]func(params json.RawMessage) (any, error){
+		"add":          s.rpcAdd,
+		"list":         s.rpcList,
+		"validate":     s.rpcValidate,
+		"search":       s.rpcSearch,
+		"fork":         s.rpcFork,
+		"resolve":      s.rpcResolve,
+		"merkle_proof": s.rpcMerkleProof,
+		"exec": func(params json.RawMessage) (any, error) {
+			return s.rpcExec(params, local)
+		},
+	}
+}
+
+// localOnlyFlags are the flags exec refuses from other machines: they delete,
+// rewrite or move chains. bc serve has no authentication, so they are only
+// run for clients on the loopback interface.
+var localOnlyFlags = map[string]bool{
+	"delete":          true,
+	"rename":          true,
+	"resolve-all":     true,
+	"undo-resolve":    true,
+	"rebase":          true,
+	"repair":          true,
+	"archive":         true,
+	"unarchive":       true,
+	"untag":           true,
+	"max-reorg-depth": true,
+}
+
+// isLoopback tells whether a request came from this machine.
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serveRPC answers a JSON-RPC 2.0 request or batch of requests. Calls run
+// one at a time, with the data directory locked.
+func (s *Server) serveRPC(w http.ResponseWriter, r *http.Request) {
+	local := isLoopback(r)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxRPCRequestSize))
+	if err != nil {
+		writeRPC(w, rpcFailure(nil, codeInvalidRequest, err.Error()))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if !bytes.HasPrefix(body, []byte("[")) {
+		if response := s.call(body, local); response != nil {
+			writeRPC(w, response)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		writeRPC(w, rpcFailure(nil, codeParseError, err.Error()))
+		return
+	}
+	if len(batch) == 0 {
+		writeRPC(w, rpcFailure(nil, codeInvalidRequest, "empty batch"))
+		return
+	}
+
+	responses := []*rpcResponse{}
+	for _, raw := range batch {
+		if response := s.call(raw, local); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPC(w, responses)
+}
+
+// call runs one request. It returns nil for a notification, a request
+// without an id, which gets no response.
+func (s *Server) call(raw json.RawMessage, local bool) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return rpcFailure(nil, codeParseError, err.Error())
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(req.ID, codeInvalidRequest, `request needs "jsonrpc": "2.0" and a method`)
+	}
+
+	method, ok := s.rpcMethods(local)[req.Method]
+	if !ok {
+		return rpcFailure(req.ID, codeMethodNotFound, fmt.Sprintf("method '%s' not found", req.Method))
+	}
+
+	s.mu.Lock()
+	_, result, err := s.locked(func() (int, any, error) {
+		result, err := method(req.Params)
+		return 0, result, err
+	})
+	s.mu.Unlock()
+
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if err != nil {
+		code := codeServerError
+		var he *httpError
+		if errors.As(err, &he) && he.status == http.StatusBadRequest {
+			code = codeInvalidParams
+		}
+		return rpcFailure(req.ID, code, err.Error())
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+func rpcFailure(id json.RawMessage, code int, message string) *rpcResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: &RPCError{Code: code, Message: message}, ID: id}
+}
+
+func writeRPC(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// decodeParams reads the params object of a call into v.
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return statusError(http.StatusBadRequest, "invalid params: %v", err)
+	}
+	return nil
+}
+
+func orMain(chain string) string {
+	if chain == "" {
+		return "main"
+	}
+	return chain
+}
+
+func (s *Server) rpcAdd(params json.RawMessage) (any, error) {
+	var p struct {
+		Chain  string                   `json:"chain"`
+		Record blockchain.StudentRecord `json:"record"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	name := orMain(p.Chain)
+	if err := fillRecord(&p.Record, name); err != nil {
+		return nil, err
+	}
+	return s.add(name, p.Record)
+}
+
+func (s *Server) rpcList(params json.RawMessage) (any, error) {
+	var p struct {
+		Chain string `json:"chain"`
+		From  string `json:"from"`
+		To    string `json:"to"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return s.blocks(orMain(p.Chain), p.From, p.To)
+}
+
+// rpcValidate validates a chain, and with other also the pair as -validate
+// does. A chain that fails is a result with valid set to
+// false, not an error.
+func (s *Server) rpcValidate(params json.RawMessage) (any, error) {
+	var p struct {
+		Chain string `json:"chain"`
+		Other string `json:"other"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	forkMgr, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{orMain(p.Chain)}
+	if p.Other != "" {
+		names = append(names, p.Other)
+	}
+	lengths := make(map[string]int)
+	for _, name := range names {
+		app, err := s.open(forkMgr, name)
+		if err != nil {
+			return nil, err
+		}
+		lengths[name] = app.bc.Length()
+
+		err = app.bc.Validate()
+		if err != nil {
+			err = fmt.Errorf("chain '%s' validation failed: %w", name, err)
+		} else {
+			err = forkMgr.CheckCheckpoints(name, app.bc)
+		}
+		if err != nil {
+			s.bus.Publish(blockchain.Event{
+				Type:   blockchain.EventValidationFailed,
+				Chain:  name,
+				Height: app.bc.Length() - 1,
+				Error:  err.Error(),
+			})
+			return map[string]any{"valid": false, "error": err.Error(), "lengths": lengths}, nil
+		}
+	}
+
+	result := map[string]any{"valid": true, "lengths": lengths}
+	if len(names) == 2 {
+		resolveMgr := resolve.NewManager(forkMgr)
+		resolveMgr.Publish(s.bus)
+		_, _, ancestor, err := resolveMgr.Check(names[0], names[1])
+		if err != nil {
+			return map[string]any{"valid": false, "error": err.Error(), "lengths": lengths}, nil
+		}
+		result["common_ancestor"] = ancestor
+	}
+	return result, nil
+}
+
+func (s *Server) rpcSearch(params json.RawMessage) (any, error) {
+	var p struct {
+		Chain string `json:"chain"`
+		Query string `json:"query"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Query == "" {
+		return nil, statusError(http.StatusBadRequest, "missing query")
+	}
+
+	forkMgr, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.open(forkMgr, orMain(p.Chain))
+	if err != nil {
+		return nil, err
+	}
+	return app.Search(p.Query)
+}
+
+// rpcMerkleProof is GET /blocks/{i}/merkle-proof/{tx} as a method.
+func (s *Server) rpcMerkleProof(params json.RawMessage) (any, error) {
+	var p struct {
+		Chain string `json:"chain"`
+		Block int    `json:"block"`
+		Tx    int    `json:"tx"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return s.merkleProof(orMain(p.Chain), p.Block, p.Tx)
+}
+
+func (s *Server) rpcFork(params json.RawMessage) (any, error) {
+	var p struct {
+		Chain string `json:"chain"`
+		Name  string `json:"name"`
+		At    string `json:"at"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Name == "" {
+		return nil, statusError(http.StatusBadRequest, "missing name of the fork")
+	}
+
+	forkMgr, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	source := orMain(p.Chain)
+	if err := forkMgr.CreateFork(source, p.Name, p.At); err != nil {
+		return nil, err
+	}
+	info, _ := forkMgr.Config.GetChain(p.Name)
+	return map[string]any{"chain": p.Name, "fork_from": source, "fork_point": *info.ForkPoint, "tip": info.Tip}, nil
+}
+
+// rpcResolve resolves two chains, or with dry_run only plans it, and
+// returns the plan. Conflicts left for a manual choice are an error: the
+// conflict file is written by the -resolve command, which exec runs.
+func (s *Server) rpcResolve(params json.RawMessage) (any, error) {
+	var p struct {
+		Chain     string `json:"chain"`
+		Other     string `json:"other"`
+		Policy    string `json:"policy"`
+		Authority string `json:"authority"`
+		DryRun    bool   `json:"dry_run"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Other == "" {
+		return nil, statusError(http.StatusBadRequest, "missing other chain")
+	}
+	if p.Policy == "" {
+		p.Policy = string(resolve.PolicyWinner)
+	}
+	policy, err := resolve.ParsePolicy(p.Policy)
+	if err != nil {
+		return nil, statusError(http.StatusBadRequest, "%v", err)
+	}
+
+	forkMgr, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	resolveMgr := resolve.NewManager(forkMgr)
+	resolveMgr.Publish(s.bus)
+
+	plan, err := resolveMgr.Plan(orMain(p.Chain), p.Other, resolve.Options{Policy: policy, Authority: p.Authority})
+	if err != nil {
+		return nil, err
+	}
+	if p.DryRun {
+		return plan, nil
+	}
+	if n := plan.Undecided(); n > 0 {
+		return nil, fmt.Errorf("%d conflicting grade(s) need a manual choice; run -resolve with -policy manual through exec", n)
+	}
+	if err := resolveMgr.Apply(plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// rpcExec runs a bc command line on the server's data directory, as -rpc
+// clients do for every command. What the command writes to stdout and
+// stderr is returned rather than printed. Command lines with one of
+// localOnlyFlags are refused unless the client is local.
+func (s *Server) rpcExec(params json.RawMessage, local bool) (any, error) {
+	var p struct {
+		Args []string `json:"args"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.Args) == 0 {
+		return nil, statusError(http.StatusBadRequest, "missing args")
+	}
+	if p.Args[0] == "node" || p.Args[0] == "serve" {
+		return nil, statusError(http.StatusBadRequest, "bc %s cannot run over RPC", p.Args[0])
+	}
+	if !local {
+		for _, arg := range p.Args {
+			if !strings.HasPrefix(arg, "-") {
+				continue
+			}
+			name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			if localOnlyFlags[name] {
+				return nil, statusError(http.StatusForbidden, "-%s only runs for clients on the server's machine", name)
+			}
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	runErr := run(p.Args, s.configFile, flag.ContinueOnError, &stdout, &stderr)
+
+	// The command line wrote the data directory without publishing.
+	if err := s.sync(true); err != nil {
+		s.log.Printf("cannot watch data directory: %v", err)
+	}
+
+	result := &ExecResult{Stdout: stdout.String(), Stderr: stderr.String()}
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	return result, nil
+}
+
+// rpcFlag finds -rpc <url> or -rpc=<url> in a command line and returns the
+// URL and the command line without it.
+func rpcFlag(args []string) (string, []string, bool) {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "rpc" && name != "-rpc" {
+			continue
+		}
+		rest := append([]string{}, args[:i]...)
+		if !hasValue {
+			if i+1 < len(args) {
+				value = args[i+1]
+				i++
+			}
+		}
+		return value, append(rest, args[i+1:]...), true
+	}
+	return "", args, false
+}
+
+// runRemote runs a command line on the bc serve at url and prints what it
+// printed there.
+func runRemote(url string, cmdline []string) error {
+	if url == "" {
+		return fmt.Errorf("-rpc needs the URL of a bc serve, like http://localhost:8080")
+	}
+	if len(cmdline) == 0 {
+		printUsage(os.Stdout)
+		return nil
+	}
+
+	var result ExecResult
+	if err := NewRPCClient(url).Call("exec", map[string]any{"args": cmdline}, &result); err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stdout, result.Stdout)
+	fmt.Fprint(os.Stderr, result.Stderr)
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// RPCClient calls the JSON-RPC methods of a bc serve.
+type RPCClient struct {
+	URL    string
+	client *http.Client
+	nextID int
+}
+
+// NewRPCClient returns a client of the bc serve at url; /rpc is appended
+// unless url already ends with it.
+func NewRPCClient(url string) *RPCClient {
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, "/rpc") {
+		url += "/rpc"
+	}
+	// Mining can take a while, so the timeout is generous.
+	return &RPCClient{URL: url, client: &http.Client{Timeout: 10 * time.Minute}}
+}
+
+// Call calls method with params and decodes its result into result.
+func (c *RPCClient) Call(method string, params, result any) error {
+	c.nextID++
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": c.nextID})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("rpc: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("rpc: %s answered %s: %w", c.URL, resp.Status, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("rpc: %w", response.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
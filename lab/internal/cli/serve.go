@@ -0,0 +1,462 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/fork"
+	"github.com/rx3lixir/lab_bc/internal/resolve"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// QueueSize bounds the records waiting to be mined by the server.
+const QueueSize = 1024
+
+// Server exposes the chains of a data directory over HTTP. It keeps one
+// fork.Manager and reloads it when the data directory changes, so bc
+// commands run alongside it are seen; mu keeps the server's own requests
+// from interleaving.
+type Server struct {
+	configFile string
+	log        *log.Logger
+	bus        *blockchain.Bus
+
+	mu      sync.Mutex
+	queue   chan queuedRecord
+	seen    watched
+	forkMgr *fork.Manager
+	loaded  [len(watchedFiles)]fileStamp
+	synced  *fork.Manager
+}
+
+// watchedFiles are the files of the data directory whose changes make the
+// server reload its fork.Manager.
+var watchedFiles = [...]string{ConfigFile, storage.BlockStoreFile, resolve.JournalFile}
+
+// fileStamp tells whether a file changed; a missing file has the zero stamp.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+type queuedRecord struct {
+	chain  string
+	record blockchain.StudentRecord
+}
+
+// httpError carries the status code an error is answered with; any other
+// error is a 500.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+
+func statusError(status int, format string, args ...any) error {
+	return &httpError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "Address to serve HTTP on")
+	dataDirFlag := fs.String("datadir", "", "Data directory (default: $"+EnvDataDir+" or the user config dir)")
+
+	fs.Parse(args)
+
+	dataDir, _, err := DataDir(*dataDirFlag)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	s := NewServer(filepath.Join(dataDir, ConfigFile))
+	if err := s.sync(false); err != nil {
+		return err
+	}
+	go s.mine()
+	go s.watch()
+
+	s.log.Printf("serving %s on %s", dataDir, *addr)
+	return http.ListenAndServe(*addr, s.Handler())
+}
+
+func NewServer(configFile string) *Server {
+	return &Server{
+		configFile: configFile,
+		log:        log.New(os.Stderr, "[serve] ", log.Ltime),
+		bus:        blockchain.NewBus(),
+		queue:      make(chan queuedRecord, QueueSize),
+		seen: watched{
+			tips:    make(map[string]string),
+			invalid: make(map[string]string),
+		},
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /chains", s.handle(s.getChains))
+	mux.HandleFunc("GET /chains/{name}/blocks", s.handle(s.getBlocks))
+	mux.HandleFunc("POST /chains/{name}/records", s.handle(s.postRecord))
+	mux.HandleFunc("GET /blocks/{hash}", s.handle(s.getBlock))
+	mux.HandleFunc("GET /blocks/{i}/merkle-proof/{tx}", s.handle(s.getMerkleProof))
+	mux.HandleFunc("GET /search", s.handle(s.getSearch))
+	mux.HandleFunc("GET /events", s.getEvents)
+	mux.HandleFunc("POST /rpc", s.serveRPC)
+	return mux
+}
+
+// handle runs fn with the data directory locked and answers its result, or
+// its error, as JSON.
+func (s *Server) handle(fn func(r *http.Request) (int, any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		status, body, err := s.locked(func() (int, any, error) { return fn(r) })
+		s.mu.Unlock()
+
+		if err != nil {
+			status = http.StatusInternalServerError
+			var he *httpError
+			if errors.As(err, &he) {
+				status = he.status
+			}
+			body = map[string]string{"error": err.Error()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(body)
+	}
+}
+
+// locked runs fn between catching up with changes made by other bc
+// commands and taking in its own, which it has published itself. Callers
+// hold s.mu.
+func (s *Server) locked(fn func() (int, any, error)) (int, any, error) {
+	if err := s.sync(true); err != nil {
+		s.log.Printf("cannot watch data directory: %v", err)
+	}
+	status, body, err := fn()
+	if err != nil {
+		// A failed change may leave the manager ahead of the files.
+		s.forkMgr = nil
+	}
+	if err := s.sync(false); err != nil {
+		s.log.Printf("cannot watch data directory: %v", err)
+	}
+	return status, body, err
+}
+
+// manager returns the server's fork.Manager, loaded again if one of
+// watchedFiles changed since it was last loaded, by a bc command or by the
+// server itself. Callers hold s.mu.
+func (s *Server) manager() (*fork.Manager, error) {
+	var stamps [len(watchedFiles)]fileStamp
+	for i, file := range watchedFiles {
+		if info, err := os.Stat(filepath.Join(filepath.Dir(s.configFile), file)); err == nil {
+			stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	if s.forkMgr != nil && stamps == s.loaded {
+		return s.forkMgr, nil
+	}
+
+	forkMgr, err := fork.NewManager(s.configFile)
+	if err != nil {
+		return nil, err
+	}
+	s.forkMgr, s.loaded = forkMgr, stamps
+	return forkMgr, nil
+}
+
+// open loads a chain into an App, as the bc command does, publishing the
+// blocks added to it.
+func (s *Server) open(forkMgr *fork.Manager, name string) (*App, error) {
+	if _, ok := forkMgr.Config.GetChain(name); !ok {
+		return nil, statusError(http.StatusNotFound, "chain '%s' not found", name)
+	}
+	store, err := forkMgr.Storage(name)
+	if err != nil {
+		return nil, err
+	}
+	app, err := NewApp(store)
+	if err != nil {
+		return nil, err
+	}
+	app.bc.Observe(s.bus.Observer(name))
+	return app, nil
+}
+
+// chainParam is the chain a block-level request refers to, main by default.
+func chainParam(r *http.Request) string {
+	if name := r.URL.Query().Get("chain"); name != "" {
+		return name
+	}
+	return "main"
+}
+
+func (s *Server) getChains(r *http.Request) (int, any, error) {
+	forkMgr, err := s.manager()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	chains := []*fork.TreeNode{}
+	var flatten func(nodes []*fork.TreeNode)
+	flatten = func(nodes []*fork.TreeNode) {
+		for _, node := range nodes {
+			children := node.Children
+			node.Children = nil
+			chains = append(chains, node)
+			flatten(children)
+		}
+	}
+	flatten(forkMgr.Tree())
+	return http.StatusOK, chains, nil
+}
+
+func (s *Server) getBlocks(r *http.Request) (int, any, error) {
+	blocks, err := s.blocks(r.PathValue("name"), r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, blocks, nil
+}
+
+// blocks lists the blocks of a chain from one reference to another; empty
+// references stand for genesis and the tip.
+func (s *Server) blocks(name, from, to string) (map[string]any, error) {
+	forkMgr, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.open(forkMgr, name)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := app.bc.Blocks()
+	first, last := 0, len(blocks)-1
+	if from != "" {
+		if first, err = forkMgr.ResolveRef(app.bc, from); err != nil {
+			return nil, statusError(http.StatusBadRequest, "from: %v", err)
+		}
+	}
+	if to != "" {
+		if last, err = forkMgr.ResolveRef(app.bc, to); err != nil {
+			return nil, statusError(http.StatusBadRequest, "to: %v", err)
+		}
+	}
+	if first > last {
+		return nil, statusError(http.StatusBadRequest, "from #%d is past to #%d", first, last)
+	}
+
+	return map[string]any{
+		"chain":  name,
+		"length": len(blocks),
+		"blocks": blocks[first : last+1],
+	}, nil
+}
+
+func (s *Server) getBlock(r *http.Request) (int, any, error) {
+	forkMgr, err := s.manager()
+	if err != nil {
+		return 0, nil, err
+	}
+	block, err := forkMgr.FindBlock(r.PathValue("hash"))
+	if err != nil {
+		return 0, nil, statusError(http.StatusNotFound, "%v", err)
+	}
+	chains, err := forkMgr.RefsContaining(block)
+	if err != nil {
+		return 0, nil, err
+	}
+	if chains == nil {
+		chains = []string{}
+	}
+	return http.StatusOK, map[string]any{"block": block, "chains": chains}, nil
+}
+
+func (s *Server) getSearch(r *http.Request) (int, any, error) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		return 0, nil, statusError(http.StatusBadRequest, "missing query parameter q")
+	}
+
+	forkMgr, err := s.manager()
+	if err != nil {
+		return 0, nil, err
+	}
+	app, err := s.open(forkMgr, chainParam(r))
+	if err != nil {
+		return 0, nil, err
+	}
+	results, err := app.Search(query)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, results, nil
+}
+
+func (s *Server) getMerkleProof(r *http.Request) (int, any, error) {
+	index, err := strconv.Atoi(r.PathValue("i"))
+	if err != nil {
+		return 0, nil, statusError(http.StatusBadRequest, "invalid block index '%s'", r.PathValue("i"))
+	}
+	tx, err := strconv.Atoi(r.PathValue("tx"))
+	if err != nil {
+		return 0, nil, statusError(http.StatusBadRequest, "invalid transaction index '%s'", r.PathValue("tx"))
+	}
+
+	proof, err := s.merkleProof(chainParam(r), index, tx)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, proof, nil
+}
+
+// merkleProof returns the Merkle proof of record tx in block index. A client
+// checks it by folding proof into tx_hash and comparing with merkle_root;
+// verified says the record still matches the block hash, which is what
+// commits to it.
+func (s *Server) merkleProof(name string, index, tx int) (map[string]any, error) {
+	forkMgr, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.open(forkMgr, name)
+	if err != nil {
+		return nil, err
+	}
+	block, err := app.bc.GetBlock(index)
+	if err != nil {
+		return nil, statusError(http.StatusNotFound, "%v", err)
+	}
+	proof, err := blockchain.MerkleProof(block, tx)
+	if err != nil {
+		return nil, statusError(http.StatusNotFound, "%v", err)
+	}
+
+	txHash := blockchain.RecordHash(&block.Data)
+	root := blockchain.MerkleRoot(block)
+	return map[string]any{
+		"chain":       name,
+		"block":       index,
+		"block_hash":  block.Hash,
+		"tx":          tx,
+		"tx_hash":     txHash,
+		"merkle_root": root,
+		"proof":       proof,
+		"verified":    block.Hash == blockchain.CalculateHash(block) && blockchain.VerifyMerkleProof(txHash, tx, proof, root),
+	}, nil
+}
+
+// postRecord mines the record in the request body into the chain, or with
+// ?mode=queue leaves it for the background miner and answers at once.
+func (s *Server) postRecord(r *http.Request) (int, any, error) {
+	var record blockchain.StudentRecord
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&record); err != nil {
+		return 0, nil, statusError(http.StatusBadRequest, "malformed record: %v", err)
+	}
+	name := r.PathValue("name")
+	if err := fillRecord(&record, name); err != nil {
+		return 0, nil, err
+	}
+
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "mine":
+		block, err := s.add(name, record)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusCreated, block, nil
+
+	case "queue":
+		forkMgr, err := s.manager()
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, ok := forkMgr.Config.GetChain(name); !ok {
+			return 0, nil, statusError(http.StatusNotFound, "chain '%s' not found", name)
+		}
+		select {
+		case s.queue <- queuedRecord{chain: name, record: record}:
+		default:
+			return 0, nil, statusError(http.StatusServiceUnavailable, "mining queue is full")
+		}
+		return http.StatusAccepted, map[string]any{"queued": true, "id": record.ID, "waiting": len(s.queue)}, nil
+
+	default:
+		return 0, nil, statusError(http.StatusBadRequest, "unknown mode '%s' (use mine or queue)", mode)
+	}
+}
+
+// fillRecord checks a record sent to the server and gives it an ID and
+// origin when it has none.
+func fillRecord(record *blockchain.StudentRecord, chain string) error {
+	if record.FullName == "" {
+		return statusError(http.StatusBadRequest, "record needs a full_name")
+	}
+	if record.Grade < 2 || record.Grade > 5 {
+		return statusError(http.StatusBadRequest, "grade must be between 2 and 5")
+	}
+	if record.ID == "" {
+		record.ID = uuid.New().String()
+	}
+	if record.Origin == "" {
+		record.Origin = chain
+	}
+	return nil
+}
+
+// add mines a record into a chain.
+func (s *Server) add(name string, record blockchain.StudentRecord) (*blockchain.Block, error) {
+	forkMgr, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.open(forkMgr, name)
+	if err != nil {
+		return nil, err
+	}
+	block, _, err := app.Add(record)
+	return block, err
+}
+
+// mine adds queued records to their chains one at a time. The queue lives
+// in memory: records still waiting when the server stops are lost.
+func (s *Server) mine() {
+	for queued := range s.queue {
+		s.mu.Lock()
+		_, _, err := s.locked(func() (int, any, error) {
+			block, err := s.add(queued.chain, queued.record)
+			if err == nil {
+				s.log.Printf("mined record %s into block #%d of '%s'", queued.record.ID, block.Index, queued.chain)
+			}
+			return 0, nil, err
+		})
+		s.mu.Unlock()
+
+		if err != nil {
+			s.log.Printf("failed to mine record %s into '%s': %v", queued.record.ID, queued.chain, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package fork
+
+import "github.com/rx3lixir/lab_bc/internal/blockchain"
+
+// CommonAncestor returns the height of the last block two registered chains
+// share, or -1. The ForkFrom/ForkPoint lineage in Config gives a height the
+// chains are expected to share; once it is confirmed, only the heights above
+// it are searched.
+func (m *Manager) CommonAncestor(name1 string, chain1 *blockchain.Blockchain, name2 string, chain2 *blockchain.Blockchain) int {
+	lo := -1
+	if hint, ok := m.lineageHint(name1, name2); ok && shared(chain1, chain2, hint) {
+		lo = hint
+	}
+	return lastShared(chain1, chain2, lo)
+}
+
+// lineageHint walks both chains up their fork lineage to the nearest chain
+// they both descend from. Every fork point crossed on the way caps the
+// height the two can be expected to share.
+func (m *Manager) lineageHint(name1, name2 string) (int, bool) {
+	caps1 := m.lineage(name1)
+	caps2 := m.lineage(name2)
+
+	best, found := -1, false
+	for chain, cap1 := range caps1 {
+		cap2, ok := caps2[chain]
+		if !ok {
+			continue
+		}
+		hint := min(cap1, cap2)
+		if !found || hint > best {
+			best, found = hint, true
+		}
+	}
+	return best, found && best >= 0
+}
+
+// lineage maps name and each chain it was forked from, directly or not, to
+// the highest height name can share with it going by recorded fork points.
+// name itself maps to an unbounded height.
+func (m *Manager) lineage(name string) map[string]int {
+	const unbounded = int(^uint(0) >> 1)
+
+	caps := map[string]int{name: unbounded}
+	height := unbounded
+	for current := name; ; {
+		info, ok := m.Config.GetChain(current)
+		if !ok || info.ForkFrom == nil || info.ForkPoint == nil {
+			return caps
+		}
+		parent := *info.ForkFrom
+		if _, seen := caps[parent]; seen {
+			return caps
+		}
+		height = min(height, *info.ForkPoint)
+		caps[parent] = height
+		current = parent
+	}
+}
+
+// lastShared finds the highest height at which both chains hold the same
+// block, given that height lo is shared (-1 when nothing is known). A block
+// hash commits to every block before it, so the chains agree on all heights
+// up to the answer and on none after it; that lets the answer be found by
+// binary search, probing chain1's hash index with chain2's blocks.
+func lastShared(chain1, chain2 *blockchain.Blockchain, lo int) int {
+	hi := min(chain1.Length(), chain2.Length()) - 1
+	if hi >= 0 && shared(chain1, chain2, hi) {
+		return hi
+	}
+
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if shared(chain1, chain2, mid) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+func shared(chain1, chain2 *blockchain.Blockchain, height int) bool {
+	if height < 0 || height >= chain2.Length() {
+		return false
+	}
+	found, ok := chain1.HeightOf(chain2.Blocks()[height].Hash)
+	return ok && found == height
+}
@@ -0,0 +1,185 @@
+package fork
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// ArchiveMeta is the fork metadata stored inside a chain archive.
+type ArchiveMeta struct {
+	Name string     `json:"name"`
+	Info *ChainInfo `json:"info"`
+}
+
+// Archive moves a chain out of the block store into a compressed,
+// checksummed archive that still carries the chain's fork metadata. Blocks
+// of the chain that no other chain or tag runs through, and that are not
+// reachable from keep, are removed from the store; it returns the archive
+// and how many blocks were removed.
+func (m *Manager) Archive(name string, keep []string) (string, int, error) {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return "", 0, fmt.Errorf("chain '%s' not found", name)
+	}
+	if storage.IsArchive(info.File) {
+		return "", 0, fmt.Errorf("chain '%s' is already archived in %s", name, info.File)
+	}
+
+	source, err := m.Storage(name)
+	if err != nil {
+		return "", 0, err
+	}
+	source.SetVerify(true)
+	bc, err := source.Load()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to load chain: %w", err)
+	}
+	if bc == nil {
+		return "", 0, fmt.Errorf("chain '%s' has no blocks yet", name)
+	}
+
+	archiveFile := chainFileName(name) + storage.ArchiveExt
+	if _, err := os.Stat(m.Path(archiveFile)); err == nil {
+		return "", 0, fmt.Errorf("file %s already exists", m.Path(archiveFile))
+	}
+	if err := storage.WriteArchive(m.Path(archiveFile), bc, ArchiveMeta{Name: name, Info: info}); err != nil {
+		return "", 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	restored, err := storage.NewGzipStorage(m.Path(archiveFile)).Load()
+	if err != nil || restored.Length() != bc.Length() {
+		os.Remove(m.Path(archiveFile))
+		return "", 0, fmt.Errorf("archive read-back failed: %v", err)
+	}
+
+	if info.File != "" {
+		m.stale = append(m.stale, info.File)
+	}
+	info.File = archiveFile
+	info.Tip = ""
+	if err := m.saveConfig(); err != nil {
+		return "", 0, fmt.Errorf("failed to save config: %w", err)
+	}
+
+	// The config no longer points at the chain, so a failure from here on
+	// only leaves unused blocks in the store.
+	pruned, err := m.prune(bc.Blocks(), keep)
+	if err != nil {
+		return "", 0, fmt.Errorf("archive written but failed to prune the block store: %w", err)
+	}
+
+	return m.Path(archiveFile), pruned, nil
+}
+
+// prune removes those of blocks that no chain tip, tag or tip in keep runs
+// through.
+func (m *Manager) prune(blocks []*blockchain.Block, keep []string) (int, error) {
+	store, err := m.BlockStore()
+	if err != nil {
+		return 0, err
+	}
+
+	roots := append([]string(nil), keep...)
+	for _, name := range m.Config.Names() {
+		if tip := m.Config.Chains[name].Tip; tip != "" {
+			roots = append(roots, tip)
+		}
+	}
+	for _, tag := range m.Config.Tags {
+		roots = append(roots, tag.Hash)
+	}
+
+	used := make(map[string]bool)
+	for _, root := range roots {
+		for hash := root; !used[hash]; {
+			block, ok := store.Get(hash)
+			if !ok {
+				break
+			}
+			used[hash] = true
+			if block.Index == 0 {
+				break
+			}
+			hash = block.PreviousHash
+		}
+	}
+
+	var unused []string
+	for _, block := range blocks {
+		if !used[block.Hash] {
+			unused = append(unused, block.Hash)
+		}
+	}
+	if store.Remove(unused...) == 0 {
+		return 0, nil
+	}
+	if err := store.Save(); err != nil {
+		return 0, err
+	}
+	return len(unused), nil
+}
+
+// Unarchive restores a chain from its archive into the block store. A parent
+// missing from the config is taken from the archive, if it is still
+// registered, so an archive copied in from elsewhere is restored with its
+// original lineage; a parent renamed or deleted since the chain was archived
+// is already in the config.
+func (m *Manager) Unarchive(name string) (string, error) {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return "", fmt.Errorf("chain '%s' not found", name)
+	}
+
+	archiveFile := info.File
+	if archiveFile == "" {
+		archiveFile = chainFileName(name)
+	}
+	if !storage.IsArchive(archiveFile) {
+		archiveFile += storage.ArchiveExt
+	}
+
+	archive := storage.NewGzipStorage(m.Path(archiveFile))
+	if !archive.Exists() {
+		return "", fmt.Errorf("chain '%s' has no archive %s", name, m.Path(archiveFile))
+	}
+
+	bc, err := archive.Load()
+	if err != nil {
+		return "", fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	var meta ArchiveMeta
+	if err := archive.ReadMeta(&meta); err != nil {
+		return "", err
+	}
+
+	if bc == nil {
+		return "", fmt.Errorf("archive %s holds no blocks", m.Path(archiveFile))
+	}
+
+	info.File = ""
+	if meta.Info != nil {
+		info.CreatedAt = meta.Info.CreatedAt
+		if parent := meta.Info.ForkFrom; info.ForkFrom == nil && parent != nil {
+			if _, ok := m.Config.GetChain(*parent); ok && *parent != name {
+				info.ForkFrom = parent
+				info.ForkPoint = meta.Info.ForkPoint
+			}
+		}
+	}
+	if err := m.writeChain(name, bc); err != nil {
+		return "", fmt.Errorf("failed to restore chain: %w", err)
+	}
+	if err := m.saveConfig(); err != nil {
+		return "", fmt.Errorf("failed to save config: %w", err)
+	}
+
+	if err := os.Remove(m.Path(archiveFile)); err != nil {
+		return "", fmt.Errorf("chain restored but failed to remove %s: %w", m.Path(archiveFile), err)
+	}
+
+	return m.Path(storage.BlockStoreFile), nil
+}
@@ -0,0 +1,156 @@
+package fork
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// newTestManager returns a manager on a new data directory holding a main
+// chain with one record past genesis.
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m, err := NewManager(filepath.Join(t.TempDir(), "fork_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Init("main"); err != nil {
+		t.Fatal(err)
+	}
+	addRecords(t, m, "main", "base")
+	return m
+}
+
+// reopen loads the data directory of m afresh, as the next bc command does.
+func reopen(t *testing.T, m *Manager) *Manager {
+	t.Helper()
+	reopened, err := NewManager(m.ConfigFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reopened
+}
+
+// addRecords mines a record for every name onto chain.
+func addRecords(t *testing.T, m *Manager, chain string, names ...string) {
+	t.Helper()
+	store, err := m.Storage(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if _, err := bc.AddBlock(blockchain.StudentRecord{ID: name, FullName: name, Grade: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Save(bc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadChain(t *testing.T, m *Manager, chain string) *blockchain.Blockchain {
+	t.Helper()
+	store, err := m.Storage(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+func tipOf(t *testing.T, m *Manager, chain string) string {
+	t.Helper()
+	blocks := loadChain(t, m, chain).Blocks()
+	return blocks[len(blocks)-1].Hash
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.CreateFork("main", "f", ""); err != nil {
+		t.Fatal(err)
+	}
+	addRecords(t, m, "f", "f1", "f2")
+	mainTip, forkTip := tipOf(t, m, "main"), tipOf(t, m, "f")
+
+	archive, pruned, err := m.Archive("f", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("archiving pruned %d blocks, want the 2 only 'f' ran through", pruned)
+	}
+	if _, err := os.Stat(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	m = reopen(t, m)
+	store, err := m.BlockStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get(forkTip); ok {
+		t.Error("the archived tip is still in the block store")
+	}
+	if got := tipOf(t, m, "f"); got != forkTip {
+		t.Errorf("archived 'f' ends at %s, want %s", got, forkTip)
+	}
+	if got := tipOf(t, m, "main"); got != mainTip {
+		t.Errorf("archiving 'f' moved main from %s to %s", mainTip, got)
+	}
+
+	if _, err := m.Unarchive("f"); err != nil {
+		t.Fatal(err)
+	}
+
+	m = reopen(t, m)
+	info, _ := m.Config.GetChain("f")
+	if info.File != "" || info.Tip != forkTip {
+		t.Errorf("unarchived 'f' has file '%s' and tip %s, want no file and tip %s", info.File, info.Tip, forkTip)
+	}
+	if info.ForkFrom == nil || *info.ForkFrom != "main" {
+		t.Errorf("unarchived 'f' forks from %v, want main", info.ForkFrom)
+	}
+	if err := loadChain(t, m, "f").Validate(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("archive %s is still there after unarchiving", archive)
+	}
+}
+
+func TestUnarchiveRefusesTamperedArchive(t *testing.T) {
+	m := newTestManager(t)
+	archive, _, err := m.Archive("main", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the archive short: gzip's own checksum no longer matches either.
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive, data[:len(data)-8], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m = reopen(t, m)
+	if _, err := m.Unarchive("main"); err == nil {
+		t.Fatal("unarchiving a damaged archive succeeded")
+	}
+	info, _ := m.Config.GetChain("main")
+	if !storage.IsArchive(info.File) || !strings.HasSuffix(archive, info.File) {
+		t.Errorf("failed unarchive left main at file '%s', want %s", info.File, archive)
+	}
+}
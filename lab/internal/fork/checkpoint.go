@@ -0,0 +1,104 @@
+package fork
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// Checkpoint marks a block as final: no resolve, rebase or undo may replace
+// the chain's blocks up to and including it.
+type Checkpoint struct {
+	Height    int    `json:"height"`
+	Hash      string `json:"hash"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// AddCheckpoint makes the block of chain referenced by at (see ResolveRef)
+// final; an empty at means the tip.
+func (m *Manager) AddCheckpoint(name, at string) (*Checkpoint, error) {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return nil, fmt.Errorf("chain '%s' not found", name)
+	}
+	bc, err := m.loadChain(name)
+	if err != nil {
+		return nil, err
+	}
+
+	height := bc.Length() - 1
+	if at != "" {
+		if height, err = m.ResolveRef(bc, at); err != nil {
+			return nil, err
+		}
+	}
+	for _, cp := range info.Checkpoints {
+		if cp.Height == height {
+			return nil, fmt.Errorf("block #%d of '%s' is already a checkpoint", height, name)
+		}
+	}
+
+	cp := &Checkpoint{Height: height, Hash: bc.Blocks()[height].Hash, CreatedAt: time.Now().Unix()}
+	info.Checkpoints = append(info.Checkpoints, cp)
+	sort.Slice(info.Checkpoints, func(i, j int) bool {
+		return info.Checkpoints[i].Height < info.Checkpoints[j].Height
+	})
+
+	if err := m.saveConfig(); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+	return cp, nil
+}
+
+// CheckCheckpoints reports the first checkpoint of name that bc does not
+// hold.
+func (m *Manager) CheckCheckpoints(name string, bc *blockchain.Blockchain) error {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return fmt.Errorf("chain '%s' not found", name)
+	}
+	for _, cp := range info.Checkpoints {
+		if cp.Height >= bc.Length() {
+			return fmt.Errorf("chain '%s' ends before checkpoint #%d", name, cp.Height)
+		}
+		if hash := bc.Blocks()[cp.Height].Hash; hash != cp.Hash {
+			return fmt.Errorf("chain '%s' conflicts with checkpoint #%d: block is %s, checkpoint is %s",
+				name, cp.Height, hash, cp.Hash)
+		}
+	}
+	return nil
+}
+
+// CheckReorg refuses to replace the blocks of name, currently bc, past
+// ancestor when that would roll back a checkpoint or go deeper than
+// MaxReorgDepth.
+func (m *Manager) CheckReorg(name string, bc *blockchain.Blockchain, ancestor int) error {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return fmt.Errorf("chain '%s' not found", name)
+	}
+
+	for _, cp := range info.Checkpoints {
+		if cp.Height > ancestor {
+			return fmt.Errorf("reorg of '%s' would roll back checkpoint #%d (%s)", name, cp.Height, cp.Hash)
+		}
+	}
+
+	depth := bc.Length() - 1 - ancestor
+	if limit := m.Config.MaxReorgDepth; limit > 0 && depth > limit {
+		return fmt.Errorf("reorg of '%s' would roll back %d blocks, more than the maximum depth of %d", name, depth, limit)
+	}
+	return nil
+}
+
+// SetMaxReorgDepth limits how many blocks a reorg may take off a chain; 0
+// lifts the limit.
+func (m *Manager) SetMaxReorgDepth(depth int) error {
+	if depth < 0 {
+		return fmt.Errorf("maximum reorg depth cannot be negative")
+	}
+	m.Config.MaxReorgDepth = depth
+	return m.saveConfig()
+}
@@ -3,18 +3,29 @@ package fork
 import (
 	"encoding/json"
 	"os"
+	"sort"
 	"time"
 )
 
+// ChainInfo describes a registered chain. A chain in the block store is
+// just its Tip; File is set for chains still kept in a file of their own,
+// which includes archives.
 type ChainInfo struct {
-	File      string  `json:"file"`
+	File      string  `json:"file,omitempty"`
+	Tip       string  `json:"tip,omitempty"`
 	CreatedAt int64   `json:"created_at"`
 	ForkFrom  *string `json:"fork_from"`
 	ForkPoint *int    `json:"fork_point"`
+
+	Checkpoints []*Checkpoint `json:"checkpoints,omitempty"`
 }
 
 type Config struct {
 	Chains map[string]*ChainInfo `json:"chains"`
+	Tags   map[string]*Tag       `json:"tags,omitempty"`
+	// MaxReorgDepth caps the blocks a reorg may take off a chain; 0 means
+	// no limit.
+	MaxReorgDepth int `json:"max_reorg_depth,omitempty"`
 }
 
 func LoadConfig(filename string) (*Config, error) {
@@ -50,6 +61,16 @@ func (c *Config) AddChain(name, file string, forkFrom *string, forkPoint *int) {
 	}
 }
 
+// Names returns the registered chain names in sorted order.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Chains))
+	for name := range c.Chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (c *Config) GetChain(name string) (*ChainInfo, bool) {
 	info, ok := c.Chains[name]
 	return info, ok
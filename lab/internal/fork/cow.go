@@ -0,0 +1,220 @@
+package fork
+
+import (
+	"fmt"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// chainStorage is the storage.Storage the Manager hands out for a chain.
+// Chains live in the block store as tips, or in an archive of their own.
+type chainStorage struct {
+	m      *Manager
+	name   string
+	verify bool
+}
+
+// Storage returns the storage for a registered chain.
+func (m *Manager) Storage(name string) (storage.Storage, error) {
+	if _, ok := m.Config.GetChain(name); !ok {
+		return nil, fmt.Errorf("chain '%s' not found in config", name)
+	}
+	return &chainStorage{m: m, name: name}, nil
+}
+
+// Filename is the chain's own file. A chain in the block store has none, but
+// still gets a per-chain name for the files kept next to it, like its index.
+func (s *chainStorage) Filename() string {
+	info, _ := s.m.Config.GetChain(s.name)
+	if info.File == "" {
+		return s.m.Path(chainFileName(s.name))
+	}
+	return s.m.Path(info.File)
+}
+
+func (s *chainStorage) Exists() bool {
+	info, _ := s.m.Config.GetChain(s.name)
+	if info.File == "" {
+		return info.Tip != ""
+	}
+	return storage.Open(s.Filename()).Exists()
+}
+
+func (s *chainStorage) SetVerify(verify bool) {
+	s.verify = verify
+}
+
+func (s *chainStorage) Load() (*blockchain.Blockchain, error) {
+	blocks, err := s.m.loadBlocks(s.name)
+	if err != nil || blocks == nil {
+		return nil, err
+	}
+
+	bc := blockchain.NewBlockchain(blocks)
+	if s.verify {
+		if err := bc.Validate(); err != nil {
+			return nil, fmt.Errorf("chain '%s': verification failed: %w", s.name, err)
+		}
+	}
+	return bc, nil
+}
+
+// Save moves the chain's tip and re-bases every fork that descends from it.
+// Blocks a fork shared with the old content stay in the block store, so
+// re-basing only updates its fork point. Archived forks hold all of their
+// blocks and are left as they are, as are forks that fail to load.
+func (s *chainStorage) Save(bc *blockchain.Blockchain) error {
+	var descendants []string
+	for _, name := range s.m.descendants(s.name) {
+		if !storage.IsArchive(s.m.Config.Chains[name].File) {
+			descendants = append(descendants, name)
+		}
+	}
+
+	// Descendants are assembled before anything is written: once this chain
+	// changes, their stored fork points may no longer exist in it.
+	chains := make(map[string]*blockchain.Blockchain, len(descendants))
+	for _, name := range descendants {
+		blocks, err := s.m.loadBlocks(name)
+		if err != nil && s.m.InStore(name) {
+			// The fork's own tip still holds all of its blocks; only its
+			// fork point goes unrefreshed.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot rewrite '%s': fork '%s' depends on it: %w", s.name, name, err)
+		}
+		if blocks != nil {
+			chains[name] = blockchain.NewBlockchain(blocks)
+		}
+	}
+
+	if err := s.m.writeChain(s.name, bc); err != nil {
+		return err
+	}
+
+	for _, name := range descendants {
+		if chains[name] == nil {
+			continue
+		}
+		if err := s.m.writeChain(name, chains[name]); err != nil {
+			return fmt.Errorf("failed to re-base fork '%s': %w", name, err)
+		}
+	}
+
+	return s.m.saveConfig()
+}
+
+// loadBlocks returns the full block list of a chain: from the block store,
+// from its archive, or from a chain file not yet moved into the store. A
+// chain with neither a tip nor a file yields nil.
+func (m *Manager) loadBlocks(name string) ([]*blockchain.Block, error) {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return nil, fmt.Errorf("chain '%s' not found in config", name)
+	}
+
+	switch {
+	case storage.IsArchive(info.File):
+		bc, err := storage.Open(m.Path(info.File)).Load()
+		if err != nil || bc == nil {
+			return nil, err
+		}
+		return bc.Blocks(), nil
+
+	case info.File != "":
+		return m.loadLegacy(name, make(map[string]bool))
+
+	case info.Tip == "":
+		return nil, nil
+	}
+
+	store, err := m.BlockStore()
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := store.Chain(info.Tip)
+	if err != nil {
+		return nil, fmt.Errorf("chain '%s': %w", name, err)
+	}
+	return blocks, nil
+}
+
+// writeChain adds the chain's blocks to the block store and points its tip
+// at the last one; a chain file not yet migrated is backed up.
+// ForkPoint is updated to the height shared with the parent. The config and
+// the store are saved by the caller, through saveConfig.
+func (m *Manager) writeChain(name string, bc *blockchain.Blockchain) error {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return fmt.Errorf("chain '%s' not found in config", name)
+	}
+
+	if storage.IsArchive(info.File) {
+		return fmt.Errorf("%s is an archived chain and is read-only (use -unarchive)", m.Path(info.File))
+	}
+
+	store, err := m.BlockStore()
+	if err != nil {
+		return err
+	}
+
+	blocks := bc.Blocks()
+	added, err := store.Put(blocks...)
+	if err != nil {
+		return fmt.Errorf("chain '%s': %w (see -validate and -repair)", name, err)
+	}
+	if added > 0 {
+		m.storeDirty = true
+	}
+
+	if info.ForkFrom != nil {
+		parent, err := m.loadBlocks(*info.ForkFrom)
+		if err == nil && parent != nil {
+			if height := commonPrefix(parent, blocks); height >= 0 {
+				info.ForkPoint = &height
+			}
+		}
+	}
+
+	if info.File != "" {
+		m.stale = append(m.stale, info.File)
+		info.File = ""
+	}
+	info.Tip = blocks[len(blocks)-1].Hash
+	return nil
+}
+
+// descendants lists every chain forked, directly or not, from name; parents
+// come before their children.
+func (m *Manager) descendants(name string) []string {
+	var result []string
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, child := range m.childNames(current) {
+			if !seen[child] {
+				seen[child] = true
+				result = append(result, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	return result
+}
+
+func commonPrefix(a, b []*blockchain.Block) int {
+	last := -1
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].Hash != b[i].Hash {
+			break
+		}
+		last = i
+	}
+	return last
+}
@@ -0,0 +1,174 @@
+package fork
+
+import (
+	"fmt"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// migrateFiles moves chains still kept in files of their own, written before
+// the block store, into the store; saveConfig then backs the files up. A
+// fork's file may hold only the blocks after its fork point, so every chain
+// is assembled before the first one moves. A chain whose blocks do not
+// validate stays in its file, for -validate and -repair.
+func (m *Manager) migrateFiles() error {
+	loaded := make(map[string][]*blockchain.Block)
+	for _, name := range m.Config.Names() {
+		file := m.Config.Chains[name].File
+		if file == "" || storage.IsArchive(file) {
+			continue
+		}
+		blocks, err := m.loadLegacy(name, make(map[string]bool))
+		if err == nil && blocks == nil {
+			err = fmt.Errorf("file is empty")
+		}
+		if err == nil {
+			if valid, invalid := blockchain.ValidPrefix(blocks); invalid != nil {
+				err = fmt.Errorf("only %d of %d blocks are valid: %w", valid, len(blocks), invalid)
+			}
+		}
+		if err != nil {
+			m.unmigrated[name] = err
+			continue
+		}
+		loaded[name] = blocks
+	}
+	if len(loaded) == 0 {
+		return nil
+	}
+
+	store, err := m.BlockStore()
+	if err != nil {
+		return err
+	}
+	for name, blocks := range loaded {
+		if _, err := store.Put(blocks...); err != nil {
+			return fmt.Errorf("chain '%s': %w", name, err)
+		}
+		info := m.Config.Chains[name]
+		m.stale = append(m.stale, info.File)
+		info.File = ""
+		info.Tip = blocks[len(blocks)-1].Hash
+	}
+	m.storeDirty = true
+	return nil
+}
+
+// loadLegacy assembles a chain from its own file, following fork deltas up
+// to a chain stored in full.
+func (m *Manager) loadLegacy(name string, seen map[string]bool) ([]*blockchain.Block, error) {
+	if seen[name] {
+		return nil, fmt.Errorf("fork lineage of '%s' contains a cycle", name)
+	}
+	seen[name] = true
+
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return nil, fmt.Errorf("chain '%s' not found in config", name)
+	}
+	if info.File == "" || storage.IsArchive(info.File) {
+		return m.loadBlocks(name)
+	}
+
+	header, own, err := storage.NewJSONStorage(m.Path(info.File)).LoadDelta()
+	if err != nil || own == nil {
+		return nil, err
+	}
+
+	if header == nil || header.Base == nil {
+		return own, nil
+	}
+
+	base := header.Base
+	if info.ForkFrom == nil || *info.ForkFrom != base.Chain {
+		return nil, fmt.Errorf("chain '%s' is a fork delta of '%s', but config does not list it as its parent", name, base.Chain)
+	}
+
+	parent, err := m.loadLegacy(base.Chain, seen)
+	if err != nil {
+		return nil, err
+	}
+	if base.Height >= len(parent) || parent[base.Height].Hash != base.Hash {
+		return nil, fmt.Errorf("chain '%s': parent '%s' no longer contains fork point #%d", name, base.Chain, base.Height)
+	}
+
+	blocks := make([]*blockchain.Block, 0, base.Height+1+len(own))
+	blocks = append(blocks, parent[:base.Height+1]...)
+	blocks = append(blocks, own...)
+
+	if blocks[0].Hash != header.ChainID {
+		return nil, fmt.Errorf("chain '%s': genesis does not match chain ID %s", name, header.ChainID)
+	}
+
+	return blocks, nil
+}
+
+// RepairReport describes what RepairFile did to a chain file.
+type RepairReport struct {
+	File      string
+	Backup    string
+	Kept      int
+	Discarded []*blockchain.Block
+	// Reason is the parse or validation error that ended the valid prefix.
+	Reason error
+}
+
+func (r *RepairReport) Repaired() bool {
+	return r.Reason != nil
+}
+
+// RepairFile truncates a chain still kept in a file of its own to its last
+// valid block and moves what is left into the block store; the file is
+// backed up as a migrated one is. A fork delta is repaired on top of its
+// parent's blocks. An intact file is left as it is.
+func (m *Manager) RepairFile(name string) (*RepairReport, error) {
+	info, ok := m.Config.GetChain(name)
+	if !ok || info.File == "" || storage.IsArchive(info.File) {
+		return nil, fmt.Errorf("chain '%s' is not kept in a chain file of its own", name)
+	}
+	file := info.File
+
+	header, blocks, parseErr := storage.NewJSONStorage(m.Path(file)).Salvage()
+	if header != nil && header.Base != nil {
+		base := header.Base
+		if info.ForkFrom == nil || *info.ForkFrom != base.Chain {
+			return nil, fmt.Errorf("chain '%s' is a fork delta of '%s', but config does not list it as its parent", name, base.Chain)
+		}
+		parent, err := m.loadLegacy(base.Chain, map[string]bool{name: true})
+		if err != nil {
+			return nil, fmt.Errorf("parent '%s' cannot be loaded (repair it first): %w", base.Chain, err)
+		}
+		if base.Height >= len(parent) || parent[base.Height].Hash != base.Hash {
+			return nil, fmt.Errorf("chain '%s': parent '%s' no longer contains fork point #%d", name, base.Chain, base.Height)
+		}
+		blocks = append(append([]*blockchain.Block{}, parent[:base.Height+1]...), blocks...)
+	}
+
+	report := &RepairReport{File: m.Path(file)}
+	var validErr error
+	if len(blocks) > 0 {
+		report.Kept, validErr = blockchain.ValidPrefix(blocks)
+		report.Discarded = blocks[report.Kept:]
+	}
+	switch {
+	case validErr != nil:
+		report.Reason = validErr
+	case parseErr != nil:
+		report.Reason = parseErr
+	default:
+		return report, nil
+	}
+	if report.Kept == 0 {
+		return report, fmt.Errorf("%s: no valid blocks to keep: %w", m.Path(file), report.Reason)
+	}
+
+	if err := m.writeChain(name, blockchain.NewBlockchain(blocks[:report.Kept])); err != nil {
+		return nil, err
+	}
+	if err := m.saveConfig(); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+	report.Backup = m.backups[file]
+	return report, nil
+}
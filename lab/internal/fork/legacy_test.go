@@ -0,0 +1,258 @@
+package fork
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// legacyDir writes a data directory as versions before the block store
+// did: main and other in files of their own, and f as a fork delta file
+// holding only the blocks after main's block #1. It returns the config
+// file and the tips of the chains.
+func legacyDir(t *testing.T) (string, map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "fork_config.json")
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	main := legacyChain(t, blockchain.NewBlockchain(nil), "m1", "m2")
+	other := legacyChain(t, blockchain.NewBlockchain(nil), "o1")
+	f := legacyChain(t, blockchain.NewBlockchain(append([]*blockchain.Block{}, main.Blocks()[:2]...)), "f1")
+
+	for name, bc := range map[string]*blockchain.Blockchain{"main": main, "other": other} {
+		if err := storage.NewJSONStorage(filepath.Join(dir, chainFileName(name))).Save(bc); err != nil {
+			t.Fatal(err)
+		}
+		cfg.AddChain(name, chainFileName(name), nil, nil)
+	}
+
+	header := storage.NewHeader(f)
+	header.Base = &storage.Base{Chain: "main", Height: 1, Hash: main.Blocks()[1].Hash}
+	data, err := json.Marshal(map[string]any{"header": header, "blocks": f.Blocks()[2:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, chainFileName("f")), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parent, point := "main", 1
+	cfg.AddChain("f", chainFileName("f"), &parent, &point)
+
+	if err := cfg.Save(configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	tips := make(map[string]string)
+	for name, bc := range map[string]*blockchain.Blockchain{"main": main, "other": other, "f": f} {
+		tips[name] = bc.Blocks()[bc.Length()-1].Hash
+	}
+	return configFile, tips
+}
+
+func legacyChain(t *testing.T, bc *blockchain.Blockchain, names ...string) *blockchain.Blockchain {
+	t.Helper()
+	for _, name := range names {
+		if _, err := bc.AddBlock(blockchain.StudentRecord{ID: name, FullName: name, Grade: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+func exists(t *testing.T, filename string) bool {
+	t.Helper()
+	_, err := os.Stat(filename)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestMigrationWaitsForAChange(t *testing.T) {
+	configFile, tips := legacyDir(t)
+	m, err := NewManager(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tip := range tips {
+		if got := tipOf(t, m, name); got != tip {
+			t.Errorf("'%s' ends at %s before migration, want %s", name, got, tip)
+		}
+	}
+	if exists(t, m.Path(storage.BlockStoreFile)) {
+		t.Error("reading the chains created the block store")
+	}
+	for name := range tips {
+		if !exists(t, m.Path(chainFileName(name))) {
+			t.Errorf("reading the chains moved the file of '%s'", name)
+		}
+	}
+}
+
+func TestMigrationMovesValidChains(t *testing.T) {
+	configFile, tips := legacyDir(t)
+	m, err := NewManager(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetMaxReorgDepth(5); err != nil {
+		t.Fatal(err)
+	}
+
+	m = reopen(t, m)
+	for name, tip := range tips {
+		info, _ := m.Config.GetChain(name)
+		if info.File != "" || info.Tip != tip {
+			t.Errorf("'%s' has file '%s' and tip %s after migration, want no file and tip %s", name, info.File, info.Tip, tip)
+		}
+		if err := loadChain(t, m, name).Validate(); err != nil {
+			t.Errorf("'%s': %v", name, err)
+		}
+		file := m.Path(chainFileName(name))
+		if exists(t, file) || !exists(t, file+".bak") {
+			t.Errorf("the file of '%s' was not moved to %s.bak", name, file)
+		}
+	}
+	if len(m.Unmigrated()) != 0 {
+		t.Errorf("unmigrated chains: %v", m.Unmigrated())
+	}
+}
+
+func TestMigrationLeavesTamperedChains(t *testing.T) {
+	configFile, tips := legacyDir(t)
+	mainFile := filepath.Join(filepath.Dir(configFile), chainFileName("main"))
+	data, err := os.ReadFile(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mainFile, bytes.ReplaceAll(data, []byte(`"m2"`), []byte(`"m3"`)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetMaxReorgDepth(5); err != nil {
+		t.Fatal(err)
+	}
+
+	reason, ok := m.Unmigrated()["main"]
+	if !ok || !strings.Contains(reason.Error(), "only 2 of 3 blocks are valid") {
+		t.Errorf("main unmigrated because of %v, want its invalid block #2", reason)
+	}
+
+	m = reopen(t, m)
+	info, _ := m.Config.GetChain("main")
+	if info.File != chainFileName("main") || !exists(t, m.Path(info.File)) {
+		t.Errorf("tampered main was taken out of its file %s", chainFileName("main"))
+	}
+	if exists(t, m.Path(chainFileName("main"))+".bak") {
+		t.Error("the file of main was backed up though it stays in use")
+	}
+
+	// f forks from main below the tampered block, so it is valid and moves.
+	for _, name := range []string{"f", "other"} {
+		info, _ := m.Config.GetChain(name)
+		if info.File != "" || info.Tip != tips[name] {
+			t.Errorf("valid '%s' has file '%s' and tip %s, want it in the block store", name, info.File, info.Tip)
+		}
+	}
+}
+
+func TestRepairFileMovesValidPrefix(t *testing.T) {
+	configFile, tips := legacyDir(t)
+	mainFile := filepath.Join(filepath.Dir(configFile), chainFileName("main"))
+	data, err := os.ReadFile(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mainFile, bytes.ReplaceAll(data, []byte(`"m2"`), []byte(`"m3"`)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := m.RepairFile("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Repaired() || report.Kept != 2 || len(report.Discarded) != 1 {
+		t.Errorf("repair kept %d blocks and discarded %d, want 2 and 1", report.Kept, len(report.Discarded))
+	}
+	if report.Backup != mainFile+".bak" || !exists(t, report.Backup) || exists(t, mainFile) {
+		t.Errorf("main's file was not moved to its backup %s", report.Backup)
+	}
+
+	m = reopen(t, m)
+	main := loadChain(t, m, "main")
+	if err := main.Validate(); err != nil {
+		t.Error(err)
+	}
+	if main.Length() != 2 {
+		t.Errorf("repaired main has %d blocks, want 2", main.Length())
+	}
+	// The fork delta on main's block #1 was moved along with the repair.
+	if got := tipOf(t, m, "f"); got != tips["f"] {
+		t.Errorf("'f' ends at %s after repairing main, want %s", got, tips["f"])
+	}
+	if _, err := m.RepairFile("main"); err == nil {
+		t.Error("repairing a chain already in the block store succeeded")
+	}
+}
+
+func TestMigrationRunsOnce(t *testing.T) {
+	configFile, tips := legacyDir(t)
+	m, err := NewManager(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetMaxReorgDepth(5); err != nil {
+		t.Fatal(err)
+	}
+
+	// A chain file copied back in after the migration is not read again.
+	mainFile := m.Path(chainFileName("main"))
+	data, err := os.ReadFile(mainFile + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mainFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m = reopen(t, m)
+	if err := m.SetMaxReorgDepth(6); err != nil {
+		t.Fatal(err)
+	}
+
+	m = reopen(t, m)
+	for name, tip := range tips {
+		if got := tipOf(t, m, name); got != tip {
+			t.Errorf("'%s' ends at %s after a second change, want %s", name, got, tip)
+		}
+	}
+	if !exists(t, mainFile) {
+		t.Error("a chain file the config does not refer to was moved")
+	}
+	matches, err := filepath.Glob(mainFile + ".bak*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("backups of main: %v, want only the one from the migration", matches)
+	}
+}
@@ -0,0 +1,284 @@
+package fork
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/index"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// Init registers a new root chain. An existing, loadable file with the
+// chain's default name is adopted into the block store; otherwise a new
+// genesis block is mined. It reports whether an existing file was adopted.
+func (m *Manager) Init(name string) (bool, error) {
+	if err := validateName(name); err != nil {
+		return false, err
+	}
+	if _, exists := m.Config.GetChain(name); exists {
+		return false, fmt.Errorf("chain '%s' already exists", name)
+	}
+	if _, exists := m.Config.Tags[name]; exists {
+		return false, fmt.Errorf("'%s' is already a tag name", name)
+	}
+
+	file := chainFileName(name)
+	existing := storage.NewJSONStorage(m.Path(file))
+	adopted := existing.Exists()
+
+	bc := blockchain.NewBlockchain(nil)
+	if adopted {
+		loaded, err := existing.Load()
+		if err == nil && loaded == nil {
+			err = fmt.Errorf("file is empty")
+		}
+		if err != nil {
+			return false, fmt.Errorf("existing file %s cannot be adopted: %w", m.Path(file), err)
+		}
+		bc = loaded
+		m.Config.AddChain(name, file, nil, nil)
+	} else {
+		m.Config.AddChain(name, "", nil, nil)
+	}
+
+	if err := m.writeChain(name, bc); err != nil {
+		delete(m.Config.Chains, name)
+		return false, fmt.Errorf("failed to create chain: %w", err)
+	}
+
+	if err := m.saveConfig(); err != nil {
+		return false, fmt.Errorf("failed to save config: %w", err)
+	}
+	return adopted, nil
+}
+
+// Import registers a new root chain holding blocks received from elsewhere,
+// such as a peer.
+func (m *Manager) Import(name string, bc *blockchain.Blockchain) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	if _, exists := m.Config.GetChain(name); exists {
+		return fmt.Errorf("chain '%s' already exists", name)
+	}
+
+	m.Config.AddChain(name, "", nil, nil)
+	if err := m.writeChain(name, bc); err != nil {
+		delete(m.Config.Chains, name)
+		return fmt.Errorf("failed to import chain: %w", err)
+	}
+	return m.saveConfig()
+}
+
+// Rename changes a chain's name, and its file and search index if it has
+// them, and points its forks at the new name.
+func (m *Manager) Rename(oldName, newName string) error {
+	info, ok := m.Config.GetChain(oldName)
+	if !ok {
+		return fmt.Errorf("chain '%s' not found", oldName)
+	}
+	if err := validateName(newName); err != nil {
+		return err
+	}
+	if _, exists := m.Config.GetChain(newName); exists {
+		return fmt.Errorf("chain '%s' already exists", newName)
+	}
+	if _, exists := m.Config.Tags[newName]; exists {
+		return fmt.Errorf("'%s' is already a tag name", newName)
+	}
+
+	// Fork deltas name their parent in the file header, so children are
+	// rewritten once the parent has its new name.
+	childNames := m.childNames(oldName)
+	children, err := m.loadChildren(oldName)
+	if err != nil {
+		return err
+	}
+
+	if info.File != "" {
+		newFile := chainFileName(newName)
+		if storage.IsArchive(info.File) {
+			newFile += storage.ArchiveExt
+		}
+		if _, err := os.Stat(m.Path(newFile)); err == nil {
+			return fmt.Errorf("file %s already exists", m.Path(newFile))
+		}
+		if err := os.Rename(m.Path(info.File), m.Path(newFile)); err != nil {
+			return fmt.Errorf("failed to rename chain file: %w", err)
+		}
+		info.File = newFile
+	}
+
+	m.Config.Chains[newName] = info
+	delete(m.Config.Chains, oldName)
+	for _, tag := range m.Config.Tags {
+		if tag.Chain == oldName {
+			tag.Chain = newName
+		}
+	}
+
+	for _, childName := range childNames {
+		m.Config.Chains[childName].ForkFrom = &newName
+		if bc := children[childName]; bc != nil {
+			if err := m.writeChain(childName, bc); err != nil {
+				return fmt.Errorf("failed to update fork '%s': %w", childName, err)
+			}
+		}
+	}
+
+	if err := m.saveConfig(); err != nil {
+		return err
+	}
+
+	// An index left under the old name would be read by a chain created
+	// with that name later.
+	if err := os.Rename(m.indexFile(oldName), m.indexFile(newName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("chain renamed but failed to rename its search index: %w", err)
+	}
+	return nil
+}
+
+// SetParent makes name a fork of parent and works out its new fork point.
+// A chain cannot fork from itself or from one of its own forks.
+func (m *Manager) SetParent(name, parent string) error {
+	if err := m.CheckParent(name, parent); err != nil {
+		return err
+	}
+	info := m.Config.Chains[name]
+
+	// The chain is read while its file, if it still has one, names the old
+	// parent.
+	blocks, err := m.loadBlocks(name)
+	if err != nil {
+		return err
+	}
+
+	info.ForkFrom = &parent
+	info.ForkPoint = nil
+	if blocks != nil {
+		if err := m.writeChain(name, blockchain.NewBlockchain(blocks)); err != nil {
+			return err
+		}
+	}
+	return m.saveConfig()
+}
+
+// CheckParent reports why name could not become a fork of parent.
+func (m *Manager) CheckParent(name, parent string) error {
+	if _, ok := m.Config.GetChain(name); !ok {
+		return fmt.Errorf("chain '%s' not found", name)
+	}
+	if _, ok := m.Config.GetChain(parent); !ok {
+		return fmt.Errorf("chain '%s' not found", parent)
+	}
+	if parent == name {
+		return fmt.Errorf("chain '%s' cannot fork from itself", name)
+	}
+	for _, descendant := range m.descendants(name) {
+		if descendant == parent {
+			return fmt.Errorf("chain '%s' is a fork of '%s'", parent, name)
+		}
+	}
+	return nil
+}
+
+// Delete removes a chain and its file and search index, if it has them. Its
+// blocks stay in the block store, addressable by hash. Chains forked from it keep it as their
+// parent, so deletion is refused unless force is set; forced deletion
+// re-parents them onto the deleted chain's own parent.
+func (m *Manager) Delete(name string, force bool) error {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return fmt.Errorf("chain '%s' not found", name)
+	}
+
+	if names := m.childNames(name); len(names) > 0 && !force {
+		return fmt.Errorf("chain '%s' is the fork_from of %s (use -force to re-parent them)",
+			name, strings.Join(names, ", "))
+	}
+
+	childNames := m.childNames(name)
+	children, err := m.loadChildren(name)
+	if err != nil {
+		return err
+	}
+
+	for _, childName := range childNames {
+		child := m.Config.Chains[childName]
+		child.ForkFrom = info.ForkFrom
+		child.ForkPoint = nil
+		if bc := children[childName]; bc != nil {
+			if err := m.writeChain(childName, bc); err != nil {
+				return fmt.Errorf("failed to re-parent fork '%s': %w", childName, err)
+			}
+		}
+	}
+
+	delete(m.Config.Chains, name)
+	if err := m.saveConfig(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	files := []string{m.indexFile(name)}
+	if info.File != "" {
+		files = append(files, m.Path(info.File))
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("chain unregistered but failed to remove %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// loadChildren assembles the direct forks of name while name is still in
+// place. Archived forks hold all of their blocks and are left out; only
+// their config entry follows the parent.
+func (m *Manager) loadChildren(name string) (map[string]*blockchain.Blockchain, error) {
+	children := make(map[string]*blockchain.Blockchain)
+	for _, child := range m.childNames(name) {
+		if storage.IsArchive(m.Config.Chains[child].File) {
+			continue
+		}
+		blocks, err := m.loadBlocks(child)
+		if err != nil {
+			return nil, fmt.Errorf("fork '%s' failed to load: %w", child, err)
+		}
+		if blocks != nil {
+			children[child] = blockchain.NewBlockchain(blocks)
+		}
+	}
+	return children, nil
+}
+
+func (m *Manager) childNames(name string) []string {
+	var names []string
+	for _, child := range m.Config.Names() {
+		info := m.Config.Chains[child]
+		if info.ForkFrom != nil && *info.ForkFrom == name {
+			names = append(names, child)
+		}
+	}
+	return names
+}
+
+func chainFileName(name string) string {
+	return fmt.Sprintf("blockchain_%s.json", name)
+}
+
+// indexFile returns the search index kept for a chain, under its name
+// whether the chain is in the block store, archived or in a file of its own.
+func (m *Manager) indexFile(name string) string {
+	return index.PathFor(m.Path(chainFileName(name)))
+}
+
+// validateName refuses names the command line could not address; "node"
+// and "serve" start the bc node and bc serve commands.
+func validateName(name string) error {
+	if name == "" || name == "node" || name == "serve" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, `/\ `) {
+		return fmt.Errorf("invalid chain name '%s'", name)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package fork
+
+import (
+	"testing"
+
+	"github.com/rx3lixir/lab_bc/internal/index"
+)
+
+// writeIndex saves the search index of chain, as bc -search does.
+func writeIndex(t *testing.T, m *Manager, chain string) {
+	t.Helper()
+	idx := index.New()
+	idx.Sync(loadChain(t, m, chain))
+	if err := idx.Save(m.indexFile(chain)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenameMovesSearchIndex(t *testing.T) {
+	m := newTestManager(t)
+	writeIndex(t, m, "main")
+	tip := tipOf(t, m, "main")
+
+	if err := m.Rename("main", "session"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(t, m.indexFile("main")) {
+		t.Error("the index is still under the old name")
+	}
+	if idx := index.Load(m.indexFile("session")); idx.Tip != tip {
+		t.Errorf("renamed index ends at %q, want %s", idx.Tip, tip)
+	}
+}
+
+func TestDeleteRemovesSearchIndex(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.CreateFork("main", "f", ""); err != nil {
+		t.Fatal(err)
+	}
+	addRecords(t, m, "f", "f1")
+	writeIndex(t, m, "f")
+
+	if err := m.Delete("f", false); err != nil {
+		t.Fatal(err)
+	}
+	if exists(t, m.indexFile("f")) {
+		t.Error("the index of the deleted chain is still there")
+	}
+}
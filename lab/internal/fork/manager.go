@@ -3,6 +3,10 @@ package fork
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
 	"github.com/rx3lixir/lab_bc/internal/storage"
@@ -11,8 +15,25 @@ import (
 type Manager struct {
 	Config     *Config
 	configFile string
+	dir        string
+
+	store      *storage.BlockStore
+	storeDirty bool
+	// stale lists chain files whose blocks moved into the block store; they
+	// are renamed to backups once the config no longer refers to them, and
+	// backups maps each to the name it got.
+	stale   []string
+	backups map[string]string
+	// migrated is set once chain files have been offered to the block
+	// store; unmigrated holds why those left in place could not move.
+	migrated   bool
+	unmigrated map[string]error
 }
 
+// NewManager loads the config from configFile. Its directory is the data
+// directory: relative chain file paths in the config are relative to it.
+// Chain files written before the block store are read as they are until
+// the first change to the data directory moves them into the store.
 func NewManager(configFile string) (*Manager, error) {
 	cfg, err := LoadConfig(configFile)
 	if err != nil {
@@ -21,20 +42,115 @@ func NewManager(configFile string) (*Manager, error) {
 	return &Manager{
 		Config:     cfg,
 		configFile: configFile,
+		dir:        filepath.Dir(configFile),
+		backups:    make(map[string]string),
+		unmigrated: make(map[string]error),
 	}, nil
 }
 
-func (m *Manager) CreateFork(sourceName, targetName string) error {
-	sourceInfo, ok := m.Config.GetChain(sourceName)
-	if !ok {
+// Dir returns the data directory.
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// ConfigFile returns the path of the config file.
+func (m *Manager) ConfigFile() string {
+	return m.configFile
+}
+
+// BlockStore opens the block store on first use.
+func (m *Manager) BlockStore() (*storage.BlockStore, error) {
+	if m.store == nil {
+		store, err := storage.OpenBlockStore(m.Path(storage.BlockStoreFile))
+		if err != nil {
+			return nil, err
+		}
+		m.store = store
+	}
+	return m.store, nil
+}
+
+// InStore reports whether a chain is a ref into the block store rather than
+// a file of its own.
+func (m *Manager) InStore(name string) bool {
+	info, ok := m.Config.GetChain(name)
+	return ok && info.File == ""
+}
+
+// saveConfig saves the block store and the config, in that order so that no
+// tip ever points at an unsaved block, and then backs up the chain files the
+// config no longer refers to. Valid chain files are moved into the block
+// store first.
+func (m *Manager) saveConfig() error {
+	if !m.migrated {
+		m.migrated = true
+		if err := m.migrateFiles(); err != nil {
+			return fmt.Errorf("failed to move chain files into the block store: %w", err)
+		}
+	}
+	if m.storeDirty {
+		if err := m.store.Save(); err != nil {
+			return fmt.Errorf("failed to save block store: %w", err)
+		}
+		m.storeDirty = false
+	}
+	if err := m.Config.Save(m.configFile); err != nil {
+		return err
+	}
+	for _, file := range m.stale {
+		backup := m.Path(file) + ".bak"
+		if _, err := os.Stat(backup); err == nil {
+			backup = fmt.Sprintf("%s.bak-%d", m.Path(file), time.Now().Unix())
+		}
+		if err := os.Rename(m.Path(file), backup); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to back up %s: %w", m.Path(file), err)
+		}
+		m.backups[file] = backup
+	}
+	m.stale = nil
+	return nil
+}
+
+// Unmigrated returns the chains still read from files of their own because
+// their blocks did not validate, with the reason.
+func (m *Manager) Unmigrated() map[string]error {
+	return m.unmigrated
+}
+
+// Path resolves a file name stored in the config against the data
+// directory.
+func (m *Manager) Path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(m.dir, file)
+}
+
+// CreateFork registers a new chain targetName branching off sourceName. The
+// new chain ends at the block referenced by at (see ResolveRef); an empty at
+// forks from the tip. Like a git branch, the fork is only a new ref to a
+// block already in the block store. The fork keeps the source's checkpoints
+// up to the fork point.
+func (m *Manager) CreateFork(sourceName, targetName, at string) error {
+	if _, ok := m.Config.GetChain(sourceName); !ok {
 		return fmt.Errorf("source chain '%s' not found", sourceName)
 	}
 
+	if err := validateName(targetName); err != nil {
+		return err
+	}
+
 	if _, exists := m.Config.GetChain(targetName); exists {
 		return fmt.Errorf("target chain '%s' already exists", targetName)
 	}
+	if _, exists := m.Config.Tags[targetName]; exists {
+		return fmt.Errorf("'%s' is already a tag name", targetName)
+	}
 
-	sourceStorage := storage.NewJSONStorage(sourceInfo.File)
+	sourceStorage, err := m.Storage(sourceName)
+	if err != nil {
+		return err
+	}
 	sourceBC, err := sourceStorage.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load source chain: %w", err)
@@ -43,62 +159,99 @@ func (m *Manager) CreateFork(sourceName, targetName string) error {
 		return fmt.Errorf("source chain is empty")
 	}
 
-	targetFile := fmt.Sprintf("blockchain_%s.json", targetName)
+	forkPoint := sourceBC.Length() - 1
+	if at != "" {
+		forkPoint, err = m.ResolveRef(sourceBC, at)
+		if err != nil {
+			return fmt.Errorf("chain '%s': %w", sourceName, err)
+		}
+	}
 
-	srcData, err := os.ReadFile(sourceInfo.File)
+	forkBC, err := sourceBC.Prefix(forkPoint)
 	if err != nil {
-		return fmt.Errorf("failed to read source file: %w", err)
+		return err
 	}
 
-	if err := os.WriteFile(targetFile, srcData, 0o644); err != nil {
-		return fmt.Errorf("failed to create fork file: %w", err)
+	m.Config.AddChain(targetName, "", &sourceName, &forkPoint)
+	source, _ := m.Config.GetChain(sourceName)
+	for _, cp := range source.Checkpoints {
+		if cp.Height <= forkPoint {
+			copied := *cp
+			m.Config.Chains[targetName].Checkpoints = append(m.Config.Chains[targetName].Checkpoints, &copied)
+		}
 	}
 
-	forkPoint := sourceBC.Length() - 1
-	m.Config.AddChain(targetName, targetFile, &sourceName, &forkPoint)
+	if err := m.writeChain(targetName, forkBC); err != nil {
+		delete(m.Config.Chains, targetName)
+		return fmt.Errorf("failed to create fork: %w", err)
+	}
 
-	if err := m.Config.Save(m.configFile); err != nil {
+	if err := m.saveConfig(); err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
 	return nil
 }
 
-func (m *Manager) GetChainFile(name string) (string, error) {
-	info, ok := m.Config.GetChain(name)
-	if !ok {
-		return "", fmt.Errorf("chain '%s' not found in config", name)
+// ResolveRef turns a block reference into a height in bc. A reference is
+// a tag name, a block height or a unique prefix of a block hash. Since
+// mined hashes start with zeros, digits with a leading zero are read as a
+// hash prefix.
+func (m *Manager) ResolveRef(bc *blockchain.Blockchain, ref string) (int, error) {
+	ref = strings.TrimSpace(ref)
+	if tag, ok := m.Config.Tags[ref]; ok {
+		height, found := bc.HeightOf(tag.Hash)
+		if !found {
+			return 0, fmt.Errorf("tagged block '%s' (#%d of '%s') is not on this chain", ref, tag.Height, tag.Chain)
+		}
+		return height, nil
 	}
-	return info.File, nil
-}
 
-func (m *Manager) RegisterChain(name string) error {
-	if _, exists := m.Config.GetChain(name); exists {
-		return nil
+	ref = strings.ToLower(ref)
+	if ref == "" {
+		return 0, fmt.Errorf("empty block reference")
 	}
 
-	file := fmt.Sprintf("blockchain_%s.json", name)
-	m.Config.AddChain(name, file, nil, nil)
-	return m.Config.Save(m.configFile)
-}
-
-func (m *Manager) FindCommonAncestor(chain1, chain2 *blockchain.Blockchain) int {
-	blocks1 := chain1.Blocks()
-	blocks2 := chain2.Blocks()
+	if height, err := strconv.Atoi(ref); err == nil && (ref == "0" || ref[0] != '0') {
+		tip := bc.Length() - 1
+		if height < 0 || height > tip {
+			return 0, fmt.Errorf("block #%d is past the tip (#%d)", height, tip)
+		}
+		return height, nil
+	}
 
-	minLen := len(blocks1)
-	if len(blocks2) < minLen {
-		minLen = len(blocks2)
+	if height, ok := bc.HeightOf(ref); ok {
+		return height, nil
 	}
 
-	lastCommon := -1
-	for i := 0; i < minLen; i++ {
-		if blocks1[i].Hash == blocks2[i].Hash {
-			lastCommon = i
-		} else {
-			break
+	match := -1
+	for _, block := range bc.Blocks() {
+		if strings.HasPrefix(block.Hash, ref) {
+			if match != -1 {
+				return 0, fmt.Errorf("hash prefix '%s' is ambiguous", ref)
+			}
+			match = block.Index
 		}
 	}
+	if match == -1 {
+		return 0, fmt.Errorf("no block with hash prefix '%s'", ref)
+	}
+	return match, nil
+}
+
+func (m *Manager) GetChainFile(name string) (string, error) {
+	info, ok := m.Config.GetChain(name)
+	if !ok {
+		return "", fmt.Errorf("chain '%s' not found in config", name)
+	}
+	if info.File == "" {
+		return m.Path(storage.BlockStoreFile), nil
+	}
+	return m.Path(info.File), nil
+}
 
-	return lastCommon
+// FindCommonAncestor returns the height of the last block both chains
+// share, or -1 if even their genesis blocks differ.
+func (m *Manager) FindCommonAncestor(chain1, chain2 *blockchain.Blockchain) int {
+	return lastShared(chain1, chain2, -1)
 }
@@ -0,0 +1,108 @@
+package fork
+
+import (
+	"fmt"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// Keep adds blocks to the block store without moving any tip, so that they
+// stay addressable once the chains holding them are rewritten. They are
+// written with the next change to the config.
+func (m *Manager) Keep(blocks ...*blockchain.Block) error {
+	store, err := m.BlockStore()
+	if err != nil {
+		return err
+	}
+	added, err := store.Put(blocks...)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		m.storeDirty = true
+	}
+	return nil
+}
+
+// ChainAt rebuilds the chain ending at a stored block, whether or not any
+// ref still points there.
+func (m *Manager) ChainAt(tip string) (*blockchain.Blockchain, error) {
+	store, err := m.BlockStore()
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := store.Chain(tip)
+	if err != nil {
+		return nil, err
+	}
+	return blockchain.NewBlockchain(blocks), nil
+}
+
+// FindBlock looks a stored block up by a unique hash prefix.
+func (m *Manager) FindBlock(prefix string) (*blockchain.Block, error) {
+	store, err := m.BlockStore()
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		return nil, fmt.Errorf("empty block hash")
+	}
+
+	found := store.Find(prefix)
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no block %s in the block store", prefix)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("block hash %s is ambiguous (%d blocks match)", prefix, len(found))
+}
+
+// RefsContaining lists the chains that have the block, at its height, in
+// their history. A block no chain contains is orphaned.
+func (m *Manager) RefsContaining(block *blockchain.Block) ([]string, error) {
+	var names []string
+	for _, name := range m.Config.Names() {
+		blocks, err := m.loadBlocks(name)
+		if err != nil {
+			return nil, fmt.Errorf("chain '%s': %w", name, err)
+		}
+		if block.Index < len(blocks) && blocks[block.Index].Hash == block.Hash {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// RepairTip points a chain in the block store whose blocks run through a
+// quarantined block at the last block before it. The blocks above stay in
+// the store. It returns the new height and how many blocks were dropped.
+func (m *Manager) RepairTip(name string) (int, int, error) {
+	info, ok := m.Config.GetChain(name)
+	if !ok || info.File != "" || info.Tip == "" {
+		return 0, 0, fmt.Errorf("chain '%s' is not in the block store", name)
+	}
+	store, err := m.BlockStore()
+	if err != nil {
+		return 0, 0, err
+	}
+	tip, dropped, err := store.LastValid(info.Tip)
+	if err != nil {
+		return 0, 0, fmt.Errorf("chain '%s': %w", name, err)
+	}
+	if dropped == 0 {
+		return 0, 0, nil
+	}
+
+	blocks, err := store.Chain(tip)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := m.writeChain(name, blockchain.NewBlockchain(blocks)); err != nil {
+		return 0, 0, err
+	}
+	if err := m.saveConfig(); err != nil {
+		return 0, 0, fmt.Errorf("failed to save config: %w", err)
+	}
+	return len(blocks) - 1, dropped, nil
+}
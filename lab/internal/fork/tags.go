@@ -0,0 +1,137 @@
+package fork
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// Tag names a block of a chain, like the end of an exam session. The block
+// is kept by hash, so a tag survives the chain moving on; it goes stray
+// when a resolve or rebase rewrites the chain below it.
+type Tag struct {
+	Chain     string `json:"chain"`
+	Hash      string `json:"hash"`
+	Height    int    `json:"height"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// StrayTag is a tag whose block is no longer part of its chain. Found is
+// false when the chain itself is gone.
+type StrayTag struct {
+	Name  string `json:"name"`
+	Tag   *Tag   `json:"tag"`
+	Found bool   `json:"chain_found"`
+}
+
+// AddTag tags the block of chain referenced by at (see ResolveRef); an
+// empty at tags the tip.
+func (m *Manager) AddTag(name, chain, at string) (*Tag, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	if _, exists := m.Config.Tags[name]; exists {
+		return nil, fmt.Errorf("tag '%s' already exists", name)
+	}
+	if _, exists := m.Config.GetChain(name); exists {
+		return nil, fmt.Errorf("'%s' is already a chain name", name)
+	}
+
+	bc, err := m.loadChain(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	height := bc.Length() - 1
+	if at != "" {
+		if height, err = m.ResolveRef(bc, at); err != nil {
+			return nil, err
+		}
+	}
+
+	tag := &Tag{
+		Chain:     chain,
+		Hash:      bc.Blocks()[height].Hash,
+		Height:    height,
+		CreatedAt: time.Now().Unix(),
+	}
+	if m.Config.Tags == nil {
+		m.Config.Tags = make(map[string]*Tag)
+	}
+	m.Config.Tags[name] = tag
+
+	if err := m.saveConfig(); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+	return tag, nil
+}
+
+// RemoveTag deletes a tag; the block it named is left alone.
+func (m *Manager) RemoveTag(name string) error {
+	if _, ok := m.Config.Tags[name]; !ok {
+		return fmt.Errorf("tag '%s' not found", name)
+	}
+	delete(m.Config.Tags, name)
+	return m.saveConfig()
+}
+
+// TagNames returns the tag names in sorted order.
+func (m *Manager) TagNames() []string {
+	names := make([]string, 0, len(m.Config.Tags))
+	for name := range m.Config.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TagChain rebuilds the chain as it was at a tagged block. The block is
+// read from the block store, so this works for stray tags too.
+func (m *Manager) TagChain(name string) (*blockchain.Blockchain, *Tag, error) {
+	tag, ok := m.Config.Tags[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("tag '%s' not found", name)
+	}
+	bc, err := m.ChainAt(tag.Hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tag '%s': %w", name, err)
+	}
+	return bc, tag, nil
+}
+
+// StrayTags lists the tags whose block is no longer on their chain.
+func (m *Manager) StrayTags() ([]*StrayTag, error) {
+	var stray []*StrayTag
+	for _, name := range m.TagNames() {
+		tag := m.Config.Tags[name]
+		if _, ok := m.Config.GetChain(tag.Chain); !ok {
+			stray = append(stray, &StrayTag{Name: name, Tag: tag})
+			continue
+		}
+
+		blocks, err := m.loadBlocks(tag.Chain)
+		if err != nil {
+			return nil, fmt.Errorf("chain '%s': %w", tag.Chain, err)
+		}
+		if tag.Height >= len(blocks) || blocks[tag.Height].Hash != tag.Hash {
+			stray = append(stray, &StrayTag{Name: name, Tag: tag, Found: true})
+		}
+	}
+	return stray, nil
+}
+
+func (m *Manager) loadChain(name string) (*blockchain.Blockchain, error) {
+	if _, ok := m.Config.GetChain(name); !ok {
+		return nil, fmt.Errorf("chain '%s' not found", name)
+	}
+	blocks, err := m.loadBlocks(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain '%s': %w", name, err)
+	}
+	if blocks == nil {
+		return nil, fmt.Errorf("chain '%s' has no blocks yet", name)
+	}
+	return blockchain.NewBlockchain(blocks), nil
+}
@@ -0,0 +1,161 @@
+package fork
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// TreeNode describes one chain in the fork tree. Ahead and Behind measure
+// divergence from the parent: blocks past their common ancestor on this
+// chain and on the parent respectively.
+type TreeNode struct {
+	Name      string      `json:"name"`
+	Length    int         `json:"length"`
+	Tip       string      `json:"tip"`
+	CreatedAt int64       `json:"created_at"`
+	ForkFrom  *string     `json:"fork_from,omitempty"`
+	ForkPoint *int        `json:"fork_point,omitempty"`
+	Ancestor  int         `json:"common_ancestor"`
+	Ahead     int         `json:"ahead"`
+	Behind    int         `json:"behind"`
+	Error     string      `json:"error,omitempty"`
+	Children  []*TreeNode `json:"children,omitempty"`
+}
+
+// Tree loads every registered chain and arranges them by lineage. Chains
+// without a registered parent are roots.
+func (m *Manager) Tree() []*TreeNode {
+	nodes := make(map[string]*TreeNode)
+	chains := make(map[string][]*blockchain.Block)
+	for _, name := range m.Config.Names() {
+		info := m.Config.Chains[name]
+		node := &TreeNode{
+			Name:      name,
+			CreatedAt: info.CreatedAt,
+			ForkFrom:  info.ForkFrom,
+			ForkPoint: info.ForkPoint,
+			Ancestor:  -1,
+		}
+		if blocks, err := m.loadBlocks(name); err != nil {
+			node.Error = err.Error()
+		} else if blocks == nil {
+			node.Error = "no chain file"
+		} else {
+			node.Length = len(blocks)
+			node.Tip = blocks[len(blocks)-1].Hash
+			chains[name] = blocks
+		}
+		nodes[name] = node
+	}
+
+	var roots []*TreeNode
+	for _, name := range m.Config.Names() {
+		node := nodes[name]
+		var parent *TreeNode
+		if node.ForkFrom != nil {
+			parent = nodes[*node.ForkFrom]
+		}
+		if parent == nil {
+			roots = append(roots, node)
+			continue
+		}
+
+		parent.Children = append(parent.Children, node)
+		if child, par := chains[name], chains[parent.Name]; child != nil && par != nil {
+			node.Ancestor = commonPrefix(child, par)
+			node.Ahead = len(child) - 1 - node.Ancestor
+			node.Behind = len(par) - 1 - node.Ancestor
+		}
+	}
+	return roots
+}
+
+// PrintTree renders the fork tree as indented ASCII.
+func PrintTree(w io.Writer, roots []*TreeNode) {
+	for i, root := range roots {
+		printNode(w, root, "", i == len(roots)-1, true)
+	}
+}
+
+func printNode(w io.Writer, node *TreeNode, prefix string, isTail, isRoot bool) {
+	connector := "├── "
+	extension := "│   "
+	if isTail {
+		connector = "└── "
+		extension = "    "
+	}
+	if isRoot {
+		connector, extension = "", ""
+	}
+
+	fmt.Fprintf(w, "%s%s%s\n", prefix, connector, describeNode(node))
+	for i, child := range node.Children {
+		printNode(w, child, prefix+extension, i == len(node.Children)-1, false)
+	}
+}
+
+func describeNode(node *TreeNode) string {
+	if node.Error != "" {
+		return fmt.Sprintf("%s [error: %s]", node.Name, node.Error)
+	}
+
+	desc := fmt.Sprintf("%s (%d blocks, tip %s)", node.Name, node.Length, shortHash(node.Tip))
+	if node.ForkFrom == nil {
+		return desc
+	}
+
+	if node.ForkPoint != nil {
+		desc += fmt.Sprintf(" forked at #%d", *node.ForkPoint)
+	}
+	if node.Ancestor >= 0 {
+		desc += fmt.Sprintf(", ancestor #%d, +%d/-%d vs %s", node.Ancestor, node.Ahead, node.Behind, *node.ForkFrom)
+	}
+	return desc
+}
+
+// WriteDOT renders the fork tree as a Graphviz digraph.
+func WriteDOT(w io.Writer, roots []*TreeNode) {
+	fmt.Fprintln(w, "digraph forks {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box, fontname=\"monospace\"];")
+
+	var walk func(node *TreeNode)
+	walk = func(node *TreeNode) {
+		label := fmt.Sprintf("%s\\n%d blocks\\ntip %s", node.Name, node.Length, shortHash(node.Tip))
+		if node.Error != "" {
+			label = fmt.Sprintf("%s\\nerror: %s", node.Name, node.Error)
+		}
+		fmt.Fprintf(w, "  %s [label=%s];\n", dotQuote(node.Name), dotQuote(label))
+
+		for _, child := range node.Children {
+			edge := ""
+			if child.ForkPoint != nil {
+				edge = fmt.Sprintf("#%d", *child.ForkPoint)
+			}
+			if child.Ancestor >= 0 {
+				edge += fmt.Sprintf(" (+%d/-%d)", child.Ahead, child.Behind)
+			}
+			fmt.Fprintf(w, "  %s -> %s [label=%s];\n", dotQuote(node.Name), dotQuote(child.Name), dotQuote(strings.TrimSpace(edge)))
+			walk(child)
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+
+	fmt.Fprintln(w, "}")
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
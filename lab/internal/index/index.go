@@ -0,0 +1,241 @@
+package index
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// KeyFields are the fields a query of the form "zachetka:202434" matches
+// exactly.
+var KeyFields = []string{"name", "zachetka", "group", "subject"}
+
+// Hit is where a record is: its block and its position within the block.
+type Hit struct {
+	Block int `json:"block"`
+	Tx    int `json:"tx"`
+}
+
+// Index maps the words and key fields of a chain's records to where the
+// records are. Height and Tip describe the last block indexed, so Sync can
+// tell whether the index can be extended or must be rebuilt.
+type Index struct {
+	Height int              `json:"height"`
+	Tip    string           `json:"tip"`
+	Tokens map[string][]Hit `json:"tokens"`
+	Keys   map[string][]Hit `json:"keys"`
+
+	grams map[string][]string
+}
+
+func New() *Index {
+	return &Index{
+		Tokens: make(map[string][]Hit),
+		Keys:   make(map[string][]Hit),
+	}
+}
+
+// PathFor returns the index file kept next to a chain file.
+func PathFor(chainFile string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(chainFile, ".gz"), ".json") + ".index.json"
+}
+
+// Load reads an index. A missing or damaged file gives an empty index: it
+// only repeats what the chain holds, and Sync rebuilds it.
+func Load(filename string) *Index {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return New()
+	}
+
+	idx := New()
+	if err := json.Unmarshal(data, idx); err != nil || idx.Tokens == nil || idx.Keys == nil {
+		return New()
+	}
+	return idx
+}
+
+func (idx *Index) Save(filename string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0o644)
+}
+
+// Sync brings the index up to date with bc: it indexes the new blocks if
+// the last block indexed is still in the chain, and starts over otherwise.
+// It reports whether the index changed.
+func (idx *Index) Sync(bc *blockchain.Blockchain) bool {
+	blocks := bc.Blocks()
+
+	if idx.Height > len(blocks) || (idx.Height > 0 && blocks[idx.Height-1].Hash != idx.Tip) {
+		*idx = *New()
+	}
+
+	if idx.Height == len(blocks) {
+		return false
+	}
+
+	for _, block := range blocks[idx.Height:] {
+		idx.BlockAdded(block)
+	}
+	return true
+}
+
+// BlockAdded indexes the next block of the chain. A block holds a single
+// record, so Tx is always 0.
+func (idx *Index) BlockAdded(block *blockchain.Block) {
+	idx.addRecord(&block.Data, Hit{Block: block.Index})
+	idx.Height = block.Index + 1
+	idx.Tip = block.Hash
+	idx.grams = nil
+}
+
+func (idx *Index) addRecord(record *blockchain.StudentRecord, hit Hit) {
+	values := []string{record.FullName, record.Zachetka, record.Group, record.Subject}
+
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, token := range Tokenize(value) {
+			if !seen[token] {
+				seen[token] = true
+				idx.Tokens[token] = append(idx.Tokens[token], hit)
+			}
+		}
+	}
+
+	for i, field := range KeyFields {
+		key := Key(field, values[i])
+		idx.Keys[key] = append(idx.Keys[key], hit)
+	}
+}
+
+// Search looks query up. A query of the form "field:value" matches that
+// field exactly. Any other query matches records where every word of the
+// query is contained in one of the record's words, so "ванов" finds
+// "Иванов" and "иван математика" finds Ivan's mathematics grade.
+func (idx *Index) Search(query string) []Hit {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+
+	if field, value, ok := strings.Cut(query, ":"); ok && isKeyField(field) {
+		return sortHits(append([]Hit(nil), idx.Keys[Key(field, value)]...))
+	}
+
+	tokens := Tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	result := idx.substringHits(tokens[0])
+	for _, token := range tokens[1:] {
+		if len(result) == 0 {
+			break
+		}
+		next := idx.substringHits(token)
+		for hit := range result {
+			if !next[hit] {
+				delete(result, hit)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(result))
+	for hit := range result {
+		hits = append(hits, hit)
+	}
+	return sortHits(hits)
+}
+
+// gramSize is the length, in runes, of the n-grams terms are looked up by.
+const gramSize = 3
+
+// substringHits returns the records with a word containing s. The terms
+// holding s are found through the n-grams of s: a term that contains s
+// contains every one of them.
+func (idx *Index) substringHits(s string) map[Hit]bool {
+	if idx.grams == nil {
+		idx.buildGrams()
+	}
+
+	var terms []string
+	runes := []rune(s)
+	if len(runes) <= gramSize {
+		terms = idx.grams[s]
+	} else {
+		for i := 0; i+gramSize <= len(runes); i++ {
+			candidates := idx.grams[string(runes[i:i+gramSize])]
+			if i == 0 || len(candidates) < len(terms) {
+				terms = candidates
+			}
+		}
+	}
+
+	hits := make(map[Hit]bool)
+	for _, term := range terms {
+		if !strings.Contains(term, s) {
+			continue
+		}
+		for _, hit := range idx.Tokens[term] {
+			hits[hit] = true
+		}
+	}
+	return hits
+}
+
+// buildGrams maps every n-gram of up to gramSize runes to the terms that
+// contain it. It is rebuilt from Tokens rather than saved with the index.
+func (idx *Index) buildGrams() {
+	idx.grams = make(map[string][]string)
+	for term := range idx.Tokens {
+		runes := []rune(term)
+		seen := make(map[string]bool)
+		for n := 1; n <= gramSize; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				gram := string(runes[i : i+n])
+				if !seen[gram] {
+					seen[gram] = true
+					idx.grams[gram] = append(idx.grams[gram], term)
+				}
+			}
+		}
+	}
+}
+
+// Tokenize splits s into lowercase words of letters and digits.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Key returns the exact-match key of a field value.
+func Key(field, value string) string {
+	return strings.ToLower(field) + ":" + strings.ToLower(strings.TrimSpace(value))
+}
+
+func isKeyField(field string) bool {
+	for _, f := range KeyFields {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortHits(hits []Hit) []Hit {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Block != hits[j].Block {
+			return hits[i].Block < hits[j].Block
+		}
+		return hits[i].Tx < hits[j].Tx
+	})
+	return hits
+}
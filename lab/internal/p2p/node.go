@@ -0,0 +1,440 @@
+package p2p
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/fork"
+)
+
+const (
+	// PollInterval is how often the node looks for blocks added to its
+	// chain by other bc commands.
+	PollInterval = time.Second
+	// RedialInterval is how long the node waits before dialing a peer it
+	// is not connected to again.
+	RedialInterval = 3 * time.Second
+)
+
+// Node serves one chain of a data directory to its peers. It announces new
+// tips, fetches the blocks behind the tips peers announce, and switches to
+// a peer's chain when the fork-choice rule prefers it.
+type Node struct {
+	Chain      string
+	Listen     string
+	Peers      []string
+	ConfigFile string
+	Log        *log.Logger
+
+	// mu serializes access to the data directory.
+	mu       sync.Mutex
+	lastTip  string
+	listener net.Listener
+
+	connsMu sync.Mutex
+	conns   map[*conn]bool
+	dialed  map[string]*conn
+	closed  chan struct{}
+}
+
+type conn struct {
+	net.Conn
+	addr string
+	mu   sync.Mutex // serializes writes
+}
+
+func (c *conn) send(msg *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return WriteMessage(c.Conn, msg)
+}
+
+func NewNode(chain, listen string, peers []string, configFile string) *Node {
+	return &Node{
+		Chain:      chain,
+		Listen:     listen,
+		Peers:      peers,
+		ConfigFile: configFile,
+		Log:        log.New(os.Stderr, fmt.Sprintf("[node %s] ", listen), log.Ltime),
+		conns:      make(map[*conn]bool),
+		dialed:     make(map[string]*conn),
+		closed:     make(chan struct{}),
+	}
+}
+
+// LoadPeers reads peer addresses, one per line; blank lines and lines
+// starting with # are skipped.
+func LoadPeers(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var peers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			peers = append(peers, line)
+		}
+	}
+	return peers, scanner.Err()
+}
+
+// Run listens for peers and keeps the node going until Close.
+func (n *Node) Run() error {
+	listener, err := net.Listen("tcp", n.Listen)
+	if err != nil {
+		return err
+	}
+	n.listener = listener
+	n.Log.Printf("serving chain '%s', %d peer(s) configured", n.Chain, len(n.Peers))
+
+	go n.dialLoop()
+	go n.pollLoop()
+
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-n.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+		go n.serve(&conn{Conn: c, addr: c.RemoteAddr().String()}, "")
+	}
+}
+
+func (n *Node) Close() {
+	close(n.closed)
+	if n.listener != nil {
+		n.listener.Close()
+	}
+	n.connsMu.Lock()
+	for c := range n.conns {
+		c.Close()
+	}
+	n.connsMu.Unlock()
+}
+
+// dialLoop keeps a connection open to every configured peer.
+func (n *Node) dialLoop() {
+	for {
+		for _, addr := range n.Peers {
+			n.connsMu.Lock()
+			_, connected := n.dialed[addr]
+			n.connsMu.Unlock()
+			if connected {
+				continue
+			}
+
+			c, err := net.DialTimeout("tcp", addr, RedialInterval)
+			if err != nil {
+				continue
+			}
+			go n.serve(&conn{Conn: c, addr: addr}, addr)
+		}
+
+		select {
+		case <-n.closed:
+			return
+		case <-time.After(RedialInterval):
+		}
+	}
+}
+
+// pollLoop announces blocks added to the chain outside the node, like by
+// bc <chain> -add.
+func (n *Node) pollLoop() {
+	for {
+		select {
+		case <-n.closed:
+			return
+		case <-time.After(PollInterval):
+		}
+
+		n.mu.Lock()
+		status, err := n.status(MsgInv)
+		changed := err == nil && status.Tip != n.lastTip
+		if changed {
+			n.lastTip = status.Tip
+		}
+		n.mu.Unlock()
+
+		if err != nil {
+			n.Log.Printf("cannot read chain: %v", err)
+			continue
+		}
+		if changed {
+			n.Log.Printf("tip is now #%d %s", status.Height, short(status.Tip))
+			n.broadcast(status, nil)
+		}
+	}
+}
+
+// serve handles one connection until it fails. dialed is the configured
+// address for outgoing connections and empty for incoming ones.
+func (n *Node) serve(c *conn, dialed string) {
+	n.connsMu.Lock()
+	n.conns[c] = true
+	if dialed != "" {
+		n.dialed[dialed] = c
+	}
+	n.connsMu.Unlock()
+
+	defer func() {
+		c.Close()
+		n.connsMu.Lock()
+		delete(n.conns, c)
+		if dialed != "" && n.dialed[dialed] == c {
+			delete(n.dialed, dialed)
+		}
+		n.connsMu.Unlock()
+	}()
+
+	n.mu.Lock()
+	hello, err := n.status(MsgHello)
+	n.mu.Unlock()
+	if err != nil || c.send(hello) != nil {
+		return
+	}
+
+	for {
+		msg, err := ReadMessage(c)
+		if err != nil {
+			return
+		}
+		if err := n.handle(c, msg); err != nil {
+			n.Log.Printf("%s: %s: %v", c.addr, msg.Type, err)
+		}
+	}
+}
+
+func (n *Node) handle(c *conn, msg *Message) error {
+	switch msg.Type {
+	case MsgHello, MsgInv:
+		if msg.Chain != n.Chain {
+			return fmt.Errorf("peer serves chain '%s', not '%s'", msg.Chain, n.Chain)
+		}
+		n.mu.Lock()
+		request, err := n.wants(msg)
+		n.mu.Unlock()
+		if err != nil || request == nil {
+			return err
+		}
+		return c.send(request)
+
+	case MsgGetBlocks:
+		n.mu.Lock()
+		reply, err := n.blocksAfter(msg.Locator)
+		n.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return c.send(reply)
+
+	case MsgBlocks:
+		n.mu.Lock()
+		accepted, err := n.accept(msg.Blocks)
+		var status *Message
+		if accepted {
+			status, _ = n.status(MsgInv)
+			if status != nil {
+				n.lastTip = status.Tip
+			}
+		}
+		n.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if status != nil {
+			n.Log.Printf("switched to %s's chain: #%d %s", c.addr, status.Height, short(status.Tip))
+			n.broadcast(status, c)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown message type")
+}
+
+func (n *Node) broadcast(msg *Message, except *conn) {
+	n.connsMu.Lock()
+	var conns []*conn
+	for c := range n.conns {
+		if c != except {
+			conns = append(conns, c)
+		}
+	}
+	n.connsMu.Unlock()
+
+	for _, c := range conns {
+		if err := c.send(msg); err != nil {
+			c.Close()
+		}
+	}
+}
+
+// load reads the chain afresh, since other bc commands may have changed it.
+// The chain is nil until it exists. Callers hold n.mu.
+func (n *Node) load() (*fork.Manager, *blockchain.Blockchain, error) {
+	forkMgr, err := fork.NewManager(n.ConfigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := forkMgr.Config.GetChain(n.Chain); !ok {
+		return forkMgr, nil, nil
+	}
+
+	store, err := forkMgr.Storage(n.Chain)
+	if err != nil {
+		return nil, nil, err
+	}
+	bc, err := store.Load()
+	return forkMgr, bc, err
+}
+
+func (n *Node) status(msgType string) (*Message, error) {
+	_, bc, err := n.load()
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{Type: msgType, Chain: n.Chain, Height: -1}
+	if bc != nil {
+		blocks := bc.Blocks()
+		msg.Genesis = blocks[0].Hash
+		msg.Height = len(blocks) - 1
+		msg.Tip = blocks[len(blocks)-1].Hash
+	}
+	return msg, nil
+}
+
+// wants returns a MsgGetBlocks for a peer status the fork-choice rule
+// prefers to the node's own chain, or nil.
+func (n *Node) wants(peer *Message) (*Message, error) {
+	if peer.Height < 0 {
+		return nil, nil
+	}
+
+	_, bc, err := n.load()
+	if err != nil {
+		return nil, err
+	}
+	if bc == nil {
+		return &Message{Type: MsgGetBlocks}, nil
+	}
+
+	if peer.Genesis != bc.Blocks()[0].Hash {
+		return nil, fmt.Errorf("peer chain has a different genesis block")
+	}
+	if _, known := bc.HeightOf(peer.Tip); known {
+		return nil, nil
+	}
+	if !prefers(peer.Height+1, peer.Tip, bc) {
+		return nil, nil
+	}
+	return &Message{Type: MsgGetBlocks, Locator: locator(bc)}, nil
+}
+
+// blocksAfter answers a locator with the blocks after the first hash of it
+// the node has, or with the whole chain.
+func (n *Node) blocksAfter(hashes []string) (*Message, error) {
+	_, bc, err := n.load()
+	if err != nil {
+		return nil, err
+	}
+	reply := &Message{Type: MsgBlocks, Blocks: []*blockchain.Block{}}
+	if bc == nil {
+		return reply, nil
+	}
+
+	from := 0
+	for _, hash := range hashes {
+		if height, ok := bc.HeightOf(hash); ok {
+			from = height + 1
+			break
+		}
+	}
+	reply.Blocks = bc.Blocks()[from:]
+	return reply, nil
+}
+
+// accept joins blocks from a peer onto the block they follow in the node's
+// chain and switches to the result if it is valid and the fork-choice rule
+// prefers it. It reports whether the chain changed.
+func (n *Node) accept(blocks []*blockchain.Block) (bool, error) {
+	if len(blocks) == 0 {
+		return false, nil
+	}
+
+	forkMgr, bc, err := n.load()
+	if err != nil {
+		return false, err
+	}
+
+	var candidate []*blockchain.Block
+	if first := blocks[0]; first.Index == 0 {
+		candidate = blocks
+	} else {
+		if bc == nil || first.Index > bc.Length() || bc.Blocks()[first.Index-1].Hash != first.PreviousHash {
+			return false, fmt.Errorf("blocks from #%d do not follow this chain", first.Index)
+		}
+		candidate = append(append([]*blockchain.Block{}, bc.Blocks()[:first.Index]...), blocks...)
+	}
+
+	received := blockchain.NewBlockchain(candidate)
+	if err := received.Validate(); err != nil {
+		return false, fmt.Errorf("rejected invalid chain: %w", err)
+	}
+
+	if bc == nil {
+		return true, forkMgr.Import(n.Chain, received)
+	}
+
+	if received.Blocks()[0].Hash != bc.Blocks()[0].Hash {
+		return false, fmt.Errorf("rejected chain with a different genesis block")
+	}
+	if !prefers(received.Length(), received.Blocks()[received.Length()-1].Hash, bc) {
+		return false, nil
+	}
+
+	ancestor := forkMgr.FindCommonAncestor(bc, received)
+	if err := forkMgr.CheckReorg(n.Chain, bc, ancestor); err != nil {
+		return false, fmt.Errorf("rejected: %w", err)
+	}
+
+	store, err := forkMgr.Storage(n.Chain)
+	if err != nil {
+		return false, err
+	}
+	if err := store.Save(received); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// prefers is the fork-choice rule between nodes: the longer chain wins, and
+// of two equally long chains the one whose tip hash sorts first, so that
+// every node settles on the same tip.
+func prefers(length int, tip string, current *blockchain.Blockchain) bool {
+	if length != current.Length() {
+		return length > current.Length()
+	}
+	return tip < current.Blocks()[current.Length()-1].Hash
+}
+
+func short(hash string) string {
+	if len(hash) > 16 {
+		return hash[:16]
+	}
+	return hash
+}
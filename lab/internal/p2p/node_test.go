@@ -0,0 +1,166 @@
+package p2p
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/fork"
+)
+
+// freeAddr returns a loopback address nothing listens on, so that nodes
+// can be given each other's addresses before they start.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// newDataDir creates a data directory whose main chain is genesis followed
+// by extra blocks of its own.
+func newDataDir(t *testing.T, genesis *blockchain.Block, extra int) string {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "fork_config.json")
+
+	bc := blockchain.NewBlockchain([]*blockchain.Block{genesis})
+	for i := 0; i < extra; i++ {
+		record := blockchain.StudentRecord{
+			FullName: fmt.Sprintf("Student %d of %s", i, configFile),
+			Zachetka: fmt.Sprintf("%06d", i),
+			Group:    "5.507M",
+			Subject:  "Математика",
+			Course:   5,
+			Grade:    5,
+		}
+		if _, err := bc.AddBlock(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	forkMgr, err := fork.NewManager(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := forkMgr.Import("main", bc); err != nil {
+		t.Fatal(err)
+	}
+	return configFile
+}
+
+// startNodes runs a node on every data directory, each dialing the node
+// before it, and stops them when the test ends.
+func startNodes(t *testing.T, configFiles []string) []*Node {
+	t.Helper()
+	var nodes []*Node
+	for i, configFile := range configFiles {
+		var peers []string
+		if i > 0 {
+			peers = []string{nodes[i-1].Listen}
+		}
+		n := NewNode("main", freeAddr(t), peers, configFile)
+		n.Log = log.New(io.Discard, "", 0)
+		nodes = append(nodes, n)
+
+		go n.Run()
+		t.Cleanup(n.Close)
+	}
+	return nodes
+}
+
+// waitForTip waits until every node's chain ends in tip.
+func waitForTip(t *testing.T, nodes []*Node, height int, tip string) {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for {
+		converged := true
+		var tips []string
+		for _, n := range nodes {
+			n.mu.Lock()
+			status, err := n.status(MsgInv)
+			n.mu.Unlock()
+			if err != nil {
+				t.Fatalf("node %s: %v", n.Listen, err)
+			}
+			tips = append(tips, fmt.Sprintf("#%d %s", status.Height, short(status.Tip)))
+			if status.Height != height || status.Tip != tip {
+				converged = false
+			}
+		}
+		if converged {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes did not converge on #%d %s: %v", height, short(tip), tips)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// loadMain reads the main chain of a data directory.
+func loadMain(t *testing.T, configFile string) *blockchain.Blockchain {
+	t.Helper()
+	forkMgr, err := fork.NewManager(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := forkMgr.Storage("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+func tipOf(t *testing.T, configFile string) (int, string) {
+	t.Helper()
+	blocks := loadMain(t, configFile).Blocks()
+	return len(blocks) - 1, blocks[len(blocks)-1].Hash
+}
+
+func TestNodesConvergeOnLongestChain(t *testing.T) {
+	genesis := blockchain.NewBlockchain(nil).Blocks()[0]
+
+	// The longest chain is at the far end of the line of nodes, so the
+	// middle node has to switch to it before it can reach the first.
+	configFiles := []string{
+		newDataDir(t, genesis, 1),
+		newDataDir(t, genesis, 2),
+		newDataDir(t, genesis, 3),
+	}
+	height, tip := tipOf(t, configFiles[2])
+
+	waitForTip(t, startNodes(t, configFiles), height, tip)
+
+	for _, configFile := range configFiles {
+		if err := loadMain(t, configFile).Validate(); err != nil {
+			t.Errorf("%s: %v", configFile, err)
+		}
+	}
+}
+
+func TestNodesBreakTiesByTipHash(t *testing.T) {
+	genesis := blockchain.NewBlockchain(nil).Blocks()[0]
+
+	configFiles := []string{
+		newDataDir(t, genesis, 2),
+		newDataDir(t, genesis, 2),
+	}
+	height, tip := tipOf(t, configFiles[0])
+	if _, other := tipOf(t, configFiles[1]); other < tip {
+		tip = other
+	}
+
+	waitForTip(t, startNodes(t, configFiles), height, tip)
+}
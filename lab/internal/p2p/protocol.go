@@ -0,0 +1,101 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// Message types. Every message is a JSON object preceded by its length as
+// a 4-byte big-endian integer.
+const (
+	// MsgHello is the first message on a connection; it carries the
+	// sender's status.
+	MsgHello = "hello"
+	// MsgInv announces a new tip.
+	MsgInv = "inv"
+	// MsgGetBlocks asks for the blocks after the first locator hash the
+	// receiver has.
+	MsgGetBlocks = "getblocks"
+	// MsgBlocks answers MsgGetBlocks.
+	MsgBlocks = "blocks"
+)
+
+// MaxMessageSize bounds a single message, so a bad peer cannot make a node
+// allocate without limit.
+const MaxMessageSize = 32 << 20
+
+type Message struct {
+	Type string `json:"type"`
+
+	// Status, for MsgHello and MsgInv. Height is -1 for a node without
+	// blocks yet.
+	Chain   string `json:"chain,omitempty"`
+	Genesis string `json:"genesis,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Tip     string `json:"tip,omitempty"`
+
+	// Locator lists block hashes from the sender's tip back to genesis,
+	// densely at first and then with doubling gaps.
+	Locator []string `json:"locator,omitempty"`
+
+	Blocks []*blockchain.Block `json:"blocks,omitempty"`
+}
+
+func WriteMessage(w io.Writer, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("%s message of %d bytes is over the limit", msg.Type, len(data))
+	}
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func ReadMessage(r io.Reader) (*Message, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes is over the limit", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("malformed message: %w", err)
+	}
+	return &msg, nil
+}
+
+// locator builds the block locator of bc: the last ten hashes, then every
+// 2nd, 4th, 8th... back from there, always ending with genesis.
+func locator(bc *blockchain.Blockchain) []string {
+	blocks := bc.Blocks()
+	var hashes []string
+	step := 1
+	for i := len(blocks) - 1; i > 0; i -= step {
+		hashes = append(hashes, blocks[i].Hash)
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+	}
+	return append(hashes, blocks[0].Hash)
+}
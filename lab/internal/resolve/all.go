@@ -0,0 +1,164 @@
+package resolve
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// PlanAll plans an N-way resolve. The winner is chosen among all chains by
+// the fork-choice rule and the others are merged into it in name order, so
+// the result does not depend on the order the chains were given in. Each
+// plan sees the records merged by the plans before it: the winner they
+// share already holds those replays, in memory only.
+func (m *Manager) PlanAll(names []string, opts Options) ([]*Plan, error) {
+	names = sortedUnique(names)
+	if len(names) < 2 {
+		return nil, fmt.Errorf("resolve-all needs at least two distinct chains")
+	}
+
+	stores := make(map[string]storage.Storage, len(names))
+	chains := make(map[string]*blockchain.Blockchain, len(names))
+	for _, name := range names {
+		store, err := m.forkMgr.Storage(name)
+		if err != nil {
+			return nil, err
+		}
+		bc, err := store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chain '%s': %w", name, err)
+		}
+		if bc == nil {
+			return nil, fmt.Errorf("chain '%s' has no blocks yet", name)
+		}
+		stores[name], chains[name] = store, bc
+	}
+
+	choice := &Plan{}
+	winner := names[0]
+	for _, name := range names[1:] {
+		if !m.chooseFirst(winner, name, chains[winner], chains[name], choice) {
+			winner = name
+		}
+	}
+
+	if opts.Policy == PolicyAuthority {
+		authority, err := m.pickAuthority(opts.Authority, names)
+		if err != nil {
+			return nil, err
+		}
+		opts.Authority = authority
+	}
+
+	winnerTip := tip(chains[winner])
+	resolvedAt := time.Now().Unix()
+
+	var plans []*Plan
+	for _, name := range names {
+		if name == winner {
+			continue
+		}
+
+		plan := &Plan{
+			Winner:        winner,
+			Loser:         name,
+			Reason:        choice.Reason,
+			winner:        chains[winner],
+			loser:         chains[name],
+			winnerStorage: stores[winner],
+			loserStorage:  stores[name],
+			winnerTip:     winnerTip,
+			participants:  names,
+		}
+		if err := m.fill(plan, opts); err != nil {
+			return nil, fmt.Errorf("chain '%s': %w", name, err)
+		}
+
+		if plan.Undecided() == 0 {
+			if err := plan.replay(resolvedAt); err != nil {
+				return nil, err
+			}
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// ResolveAll merges every chain into the winner of PlanAll and writes the
+// result to all of them.
+func (m *Manager) ResolveAll(names []string, opts Options) error {
+	plans, err := m.PlanAll(names, opts)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, plan := range plans {
+		if plan.Undecided() > 0 {
+			if err := plan.writeConflicts(); err != nil {
+				return fmt.Errorf("failed to write conflict file: %w", err)
+			}
+			pending = append(pending, plan.ConflictFile)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("conflicting grades need a manual choice: set \"choice\" to \"winner\" or \"loser\" in %s and run -resolve-all again",
+			strings.Join(pending, ", "))
+	}
+
+	first := plans[0]
+	last := plans[len(plans)-1]
+	fmt.Fprintf(m.out, "Winner: '%s' (%d blocks) - %s\n", first.Winner, first.WinnerLength, first.Reason)
+
+	j, reorg, err := m.beginReorg(plans)
+	if err != nil {
+		return err
+	}
+
+	if err := first.winnerStorage.Save(first.winner); err != nil {
+		return fmt.Errorf("failed to save winner chain: %w", err)
+	}
+
+	for _, plan := range plans {
+		if err := plan.loserStorage.Save(plan.winner); err != nil {
+			return fmt.Errorf("failed to save chain '%s': %w", plan.Loser, err)
+		}
+		if plan.ConflictFile != "" {
+			if err := os.Remove(plan.ConflictFile); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("chains resolved but failed to remove %s: %w", plan.ConflictFile, err)
+			}
+		}
+
+		fmt.Fprintf(m.out, "  '%s': added %d records, skipped %d duplicates", plan.Loser, len(plan.Replay), len(plan.Skipped))
+		if len(plan.Conflicts) > 0 {
+			fmt.Fprintf(m.out, ", settled %d conflicts", len(plan.Conflicts))
+		}
+		fmt.Fprintln(m.out)
+	}
+
+	if err := m.endReorg(j, reorg, plans); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(m.out, "✓ Resolve complete (reorg #%d, undo with -undo-resolve %d)\n", reorg.ID, reorg.ID)
+	fmt.Fprintf(m.out, "  All %d chains now have %d blocks\n", len(plans)+1, last.ResultLength)
+	return nil
+}
+
+func sortedUnique(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	var result []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,178 @@
+package resolve
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/fork"
+)
+
+// newTestManager returns a resolve manager on a new data directory holding
+// a main chain with one record, base, past genesis.
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	forkMgr, err := fork.NewManager(filepath.Join(t.TempDir(), "fork_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := forkMgr.Init("main"); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(forkMgr)
+	m.SetOutput(io.Discard)
+	addRecords(t, m, "main", "base")
+	return m
+}
+
+// addRecords mines a record for every name onto chain, using the name as
+// the record's ID too.
+func addRecords(t *testing.T, m *Manager, chain string, names ...string) {
+	t.Helper()
+	store, err := m.forkMgr.Storage(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		record := blockchain.StudentRecord{
+			ID:       name,
+			FullName: name,
+			Zachetka: "202434",
+			Group:    "5.507M",
+			Subject:  "Математика",
+			Course:   5,
+			Grade:    5,
+		}
+		if _, err := bc.AddBlock(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Save(bc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// forkChains forks every name from main's tip.
+func forkChains(t *testing.T, m *Manager, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := m.forkMgr.CreateFork("main", name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// recordNames returns the names on chain's records past genesis.
+func recordNames(t *testing.T, m *Manager, chain string) []string {
+	t.Helper()
+	bc, err := m.load(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, block := range bc.Blocks()[1:] {
+		names = append(names, block.Data.FullName)
+	}
+	return names
+}
+
+func tipOf(t *testing.T, m *Manager, chain string) string {
+	t.Helper()
+	bc, err := m.load(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tip(bc)
+}
+
+func TestPlanAllIgnoresArgumentOrder(t *testing.T) {
+	m := newTestManager(t)
+	forkChains(t, m, "a", "b", "c", "d")
+	addRecords(t, m, "a", "a1")
+	addRecords(t, m, "b", "b1")
+	addRecords(t, m, "c", "c1", "c2")
+	addRecords(t, m, "d", "d1")
+
+	orders := [][]string{
+		{"a", "b", "c", "d"},
+		{"d", "c", "b", "a"},
+		{"b", "d", "a", "c", "b"},
+	}
+	for _, names := range orders {
+		plans, err := m.PlanAll(names, Options{Policy: PolicyWinner})
+		if err != nil {
+			t.Fatalf("%v: %v", names, err)
+		}
+		var losers []string
+		for _, plan := range plans {
+			if plan.Winner != "c" {
+				t.Errorf("%v: winner is '%s', want the longest chain 'c'", names, plan.Winner)
+			}
+			losers = append(losers, plan.Loser)
+		}
+		if want := []string{"a", "b", "d"}; !reflect.DeepEqual(losers, want) {
+			t.Errorf("%v: losers merged in order %v, want %v", names, losers, want)
+		}
+	}
+}
+
+func TestPlanAllBreaksTiesByName(t *testing.T) {
+	m := newTestManager(t)
+	forkChains(t, m, "x", "y")
+	addRecords(t, m, "x", "x1")
+	addRecords(t, m, "y", "y1")
+
+	for _, names := range [][]string{{"x", "y"}, {"y", "x"}} {
+		plans, err := m.PlanAll(names, Options{Policy: PolicyWinner})
+		if err != nil {
+			t.Fatalf("%v: %v", names, err)
+		}
+		if plans[0].Winner != "x" {
+			t.Errorf("%v: winner is '%s', want 'x', named first", names, plans[0].Winner)
+		}
+	}
+}
+
+func TestResolveAllAppendsLosersInNameOrder(t *testing.T) {
+	m := newTestManager(t)
+	forkChains(t, m, "a", "b", "c")
+	addRecords(t, m, "a", "a1")
+	addRecords(t, m, "b", "b1", "b2", "b3")
+	addRecords(t, m, "c", "c1", "c2")
+
+	if err := m.ResolveAll([]string{"c", "b", "a", "main"}, Options{Policy: PolicyWinner}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"base", "b1", "b2", "b3", "a1", "c1", "c2"}
+	resultTip := tipOf(t, m, "b")
+	for _, chain := range []string{"main", "a", "b", "c"} {
+		if got := recordNames(t, m, chain); !reflect.DeepEqual(got, want) {
+			t.Errorf("'%s' holds %v, want %v", chain, got, want)
+		}
+		if got := tipOf(t, m, chain); got != resultTip {
+			t.Errorf("'%s' ends at %s, want the winner's tip %s", chain, got, resultTip)
+		}
+	}
+
+	j, err := m.Reorgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Reorgs) != 1 || j.Reorgs[0].Winner != "b" || len(j.Reorgs[0].Losers) != 3 {
+		t.Fatalf("journal holds %+v, want one reorg won by 'b' with 3 losers", j.Reorgs)
+	}
+}
+
+func TestResolveAllNeedsTwoChains(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.ResolveAll([]string{"main", "main"}, Options{Policy: PolicyWinner}); err == nil {
+		t.Fatal("resolving a chain with itself succeeded")
+	}
+}
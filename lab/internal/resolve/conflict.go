@@ -0,0 +1,215 @@
+package resolve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Policy decides which side of a grade conflict is kept.
+type Policy string
+
+const (
+	// PolicyWinner keeps the winning chain's grade.
+	PolicyWinner Policy = "winner"
+	// PolicyLatest keeps the most recently issued grade.
+	PolicyLatest Policy = "latest"
+	// PolicyAuthority keeps the grade recorded on the authoritative chain.
+	PolicyAuthority Policy = "authority"
+	// PolicyManual stops and writes a conflict file for a person to decide.
+	PolicyManual Policy = "manual"
+)
+
+const (
+	ChoiceWinner = "winner"
+	ChoiceLoser  = "loser"
+)
+
+// Options tune how Plan treats conflicting grades.
+type Options struct {
+	Policy Policy
+	// Authority names the authoritative chain for PolicyAuthority. When
+	// empty, whichever of the two chains is a root chain is used.
+	Authority string
+}
+
+// ParsePolicy accepts a policy name as given on the command line.
+func ParsePolicy(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case PolicyWinner, PolicyLatest, PolicyAuthority, PolicyManual:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy '%s' (use winner, latest, authority or manual)", name)
+}
+
+// Conflict is a student graded differently in the same subject and course
+// on both sides of the fork. Choice is ChoiceWinner or ChoiceLoser, or
+// empty while it awaits a manual decision.
+type Conflict struct {
+	Zachetka string        `json:"zachetka"`
+	Subject  string        `json:"subject"`
+	Course   int           `json:"course"`
+	Winner   PlannedRecord `json:"winner"`
+	Loser    PlannedRecord `json:"loser"`
+	Choice   string        `json:"choice"`
+}
+
+// conflictFile is what PolicyManual leaves behind. The tips pin it to the
+// exact chains it was written for.
+type conflictFile struct {
+	Winner    string      `json:"winner"`
+	WinnerTip string      `json:"winner_tip"`
+	Loser     string      `json:"loser"`
+	LoserTip  string      `json:"loser_tip"`
+	Conflicts []*Conflict `json:"conflicts"`
+}
+
+type conflictKey struct {
+	zachetka string
+	subject  string
+	course   int
+}
+
+// ConflictFileName is where conflicts between two chains are written for a
+// manual decision.
+func ConflictFileName(winner, loser string) string {
+	return fmt.Sprintf("resolve_%s_%s.conflicts.json", winner, loser)
+}
+
+// decide settles every conflict of the plan by its policy. Under
+// PolicyManual choices come from the conflict file, if one was written.
+func (m *Manager) decide(plan *Plan, opts Options) error {
+	if len(plan.Conflicts) == 0 {
+		return nil
+	}
+
+	switch plan.Policy {
+	case PolicyWinner:
+		for _, c := range plan.Conflicts {
+			c.Choice = ChoiceWinner
+		}
+	case PolicyLatest:
+		for _, c := range plan.Conflicts {
+			c.Choice = ChoiceWinner
+			if c.Loser.issuedAt() > c.Winner.issuedAt() {
+				c.Choice = ChoiceLoser
+			}
+		}
+	case PolicyAuthority:
+		authority, err := m.authority(plan, opts.Authority)
+		if err != nil {
+			return err
+		}
+		choice := ChoiceWinner
+		if authority == plan.Loser {
+			choice = ChoiceLoser
+		}
+		for _, c := range plan.Conflicts {
+			c.Choice = choice
+		}
+	case PolicyManual:
+		return plan.readChoices()
+	default:
+		return fmt.Errorf("unknown policy '%s'", plan.Policy)
+	}
+	return nil
+}
+
+func (m *Manager) authority(plan *Plan, name string) (string, error) {
+	chains := plan.participants
+	if chains == nil {
+		chains = []string{plan.Winner, plan.Loser}
+	}
+	return m.pickAuthority(name, chains)
+}
+
+// pickAuthority checks a named authority against the chains being resolved,
+// or picks the first root chain among them.
+func (m *Manager) pickAuthority(name string, chains []string) (string, error) {
+	if name != "" {
+		for _, chain := range chains {
+			if chain == name {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("authority '%s' is not one of the chains being resolved", name)
+	}
+
+	for _, chain := range chains {
+		if info, ok := m.forkMgr.Config.GetChain(chain); ok && info.ForkFrom == nil {
+			return chain, nil
+		}
+	}
+	return "", fmt.Errorf("none of %s is a root chain; name one with -authority", strings.Join(chains, ", "))
+}
+
+// readChoices copies decisions from an existing conflict file into the plan.
+// A missing file leaves the conflicts undecided.
+func (p *Plan) readChoices() error {
+	data, err := os.ReadFile(p.ConflictFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read conflict file: %w", err)
+	}
+
+	var file conflictFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", p.ConflictFile, err)
+	}
+
+	if file.WinnerTip != p.winnerTip || file.LoserTip != p.loserTip {
+		return fmt.Errorf("%s was written for different chain tips; delete it and run -resolve again", p.ConflictFile)
+	}
+
+	choices := make(map[string]string, len(file.Conflicts))
+	for _, c := range file.Conflicts {
+		if c.Choice != "" && c.Choice != ChoiceWinner && c.Choice != ChoiceLoser {
+			return fmt.Errorf("%s: invalid choice '%s' for %s/%s (use winner or loser)",
+				p.ConflictFile, c.Choice, c.Zachetka, c.Subject)
+		}
+		choices[c.id()] = c.Choice
+	}
+
+	for _, c := range p.Conflicts {
+		c.Choice = choices[c.id()]
+	}
+	return nil
+}
+
+// writeConflicts saves the plan's conflicts for a manual decision.
+func (p *Plan) writeConflicts() error {
+	file := conflictFile{
+		Winner:    p.Winner,
+		WinnerTip: p.winnerTip,
+		Loser:     p.Loser,
+		LoserTip:  p.loserTip,
+		Conflicts: p.Conflicts,
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.ConflictFile, data, 0o644)
+}
+
+// id identifies a conflict by its records rather than their blocks, which
+// are mined anew each time a record is replayed.
+func (c *Conflict) id() string {
+	return c.Winner.Record.ID + "/" + c.Loser.Record.ID
+}
+
+// Undecided counts conflicts still waiting for a manual choice.
+func (p *Plan) Undecided() int {
+	n := 0
+	for _, c := range p.Conflicts {
+		if c.Choice == "" {
+			n++
+		}
+	}
+	return n
+}
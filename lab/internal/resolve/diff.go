@@ -0,0 +1,222 @@
+package resolve
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// Status of a divergent record in a Diff.
+const (
+	// StatusOnly marks a record the other chain does not have.
+	StatusOnly = "only"
+	// StatusMoved marks a record the other chain holds in a different block.
+	StatusMoved = "moved"
+	// StatusConflict marks a grade the other side's branch contradicts.
+	StatusConflict = "conflict"
+)
+
+// Diff compares two chains block by block after their common ancestor.
+type Diff struct {
+	Left           string       `json:"left"`
+	Right          string       `json:"right"`
+	LeftLength     int          `json:"left_length"`
+	RightLength    int          `json:"right_length"`
+	CommonAncestor int          `json:"common_ancestor"`
+	LeftBlocks     []*DiffEntry `json:"left_blocks"`
+	RightBlocks    []*DiffEntry `json:"right_blocks"`
+}
+
+// DiffEntry is one divergent block. Other is the block on the other chain
+// that the status refers to, or -1 for StatusOnly.
+type DiffEntry struct {
+	PlannedRecord
+	Status string `json:"status"`
+	Other  int    `json:"other"`
+}
+
+// Diff loads both chains and classifies every block past their common
+// ancestor. Either side may be a tag, standing for its chain as it was at
+// the tagged block.
+func (m *Manager) Diff(leftName, rightName string) (*Diff, error) {
+	left, leftChain, err := m.loadSide(leftName)
+	if err != nil {
+		return nil, err
+	}
+	right, rightChain, err := m.loadSide(rightName)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestor := m.forkMgr.CommonAncestor(leftChain, left, rightChain, right)
+	diff := &Diff{
+		Left:           leftName,
+		Right:          rightName,
+		LeftLength:     left.Length(),
+		RightLength:    right.Length(),
+		CommonAncestor: ancestor,
+	}
+	diff.LeftBlocks = compareSide(left, right, ancestor)
+	diff.RightBlocks = compareSide(right, left, ancestor)
+	return diff, nil
+}
+
+func (m *Manager) load(name string) (*blockchain.Blockchain, error) {
+	store, err := m.forkMgr.Storage(name)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain '%s': %w", name, err)
+	}
+	if bc == nil {
+		return nil, fmt.Errorf("chain '%s' has no blocks yet", name)
+	}
+	return bc, nil
+}
+
+// loadSide loads a chain or a tag, and names the chain whose lineage it
+// follows.
+func (m *Manager) loadSide(name string) (*blockchain.Blockchain, string, error) {
+	if _, ok := m.forkMgr.Config.GetChain(name); !ok {
+		if _, ok := m.forkMgr.Config.Tags[name]; ok {
+			bc, tag, err := m.forkMgr.TagChain(name)
+			if err != nil {
+				return nil, "", err
+			}
+			return bc, tag.Chain, nil
+		}
+	}
+	bc, err := m.load(name)
+	return bc, name, err
+}
+
+// compareSide classifies the divergent blocks of one chain against the
+// other one.
+func compareSide(own, other *blockchain.Blockchain, ancestor int) []*DiffEntry {
+	byID := make(map[string]int)
+	for _, block := range other.Blocks() {
+		if block.Data.ID != "" {
+			byID[block.Data.ID] = block.Index
+		}
+	}
+
+	graded := make(map[conflictKey]*blockchain.Block)
+	for _, block := range other.Blocks()[ancestor+1:] {
+		if key, ok := keyOf(block.Data); ok {
+			graded[key] = block
+		}
+	}
+
+	entries := []*DiffEntry{}
+	for _, block := range own.Blocks()[ancestor+1:] {
+		entry := &DiffEntry{PlannedRecord: plannedRecord(block), Status: StatusOnly, Other: -1}
+		if index, ok := byID[block.Data.ID]; ok {
+			entry.Status, entry.Other = StatusMoved, index
+		} else if key, ok := keyOf(block.Data); ok {
+			if rival := graded[key]; rival != nil && rival.Data.Grade != block.Data.Grade {
+				entry.Status, entry.Other = StatusConflict, rival.Index
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// WriteText prints the divergent blocks of both chains side by side.
+func (d *Diff) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Common ancestor: %s\n", d.ancestorLabel())
+	fmt.Fprintf(w, "%-44s | %s\n", fmt.Sprintf("%s (%d blocks)", d.Left, d.LeftLength),
+		fmt.Sprintf("%s (%d blocks)", d.Right, d.RightLength))
+	fmt.Fprintf(w, "%s-+-%s\n", strings.Repeat("-", 44), strings.Repeat("-", 44))
+
+	rows := len(d.LeftBlocks)
+	if len(d.RightBlocks) > rows {
+		rows = len(d.RightBlocks)
+	}
+	for i := 0; i < rows; i++ {
+		var left, right *DiffEntry
+		if i < len(d.LeftBlocks) {
+			left = d.LeftBlocks[i]
+		}
+		if i < len(d.RightBlocks) {
+			right = d.RightBlocks[i]
+		}
+		fmt.Fprintf(w, "%-44s | %s\n", cell(left), cell(right))
+		fmt.Fprintf(w, "  %-42s |   %s\n", note(left), note(right))
+	}
+
+	if rows == 0 {
+		fmt.Fprintln(w, "Chains are identical.")
+	}
+}
+
+// WriteUnified prints the diff like a unified diff: '-' and '+' for
+// records only one chain has, ' ' for records both hold in different
+// blocks and '!' for conflicting grades.
+func (d *Diff) WriteUnified(w io.Writer) {
+	fmt.Fprintf(w, "--- %s (%d blocks)\n", d.Left, d.LeftLength)
+	fmt.Fprintf(w, "+++ %s (%d blocks)\n", d.Right, d.RightLength)
+	fmt.Fprintf(w, "@@ %s @@\n", d.ancestorLabel())
+
+	for _, entry := range d.LeftBlocks {
+		fmt.Fprintf(w, "%s%s\n", marker(entry, "-"), line(entry))
+	}
+	for _, entry := range d.RightBlocks {
+		fmt.Fprintf(w, "%s%s\n", marker(entry, "+"), line(entry))
+	}
+}
+
+func (d *Diff) ancestorLabel() string {
+	if d.CommonAncestor < 0 {
+		return "none (different genesis)"
+	}
+	return fmt.Sprintf("block #%d", d.CommonAncestor)
+}
+
+func marker(entry *DiffEntry, only string) string {
+	switch entry.Status {
+	case StatusMoved:
+		return " "
+	case StatusConflict:
+		return "!"
+	}
+	return only
+}
+
+func line(entry *DiffEntry) string {
+	s := fmt.Sprintf("#%d %s | %s | %s | course %d | grade %d (id %s)",
+		entry.Block, entry.Record.FullName, entry.Record.Zachetka, entry.Record.Subject,
+		entry.Record.Course, entry.Record.Grade, entry.Record.ID)
+	if n := note(entry); n != "" {
+		s += " " + n
+	}
+	return s
+}
+
+func cell(entry *DiffEntry) string {
+	if entry == nil {
+		return ""
+	}
+	s := fmt.Sprintf("#%d %s, %s: %d", entry.Block, entry.Record.FullName, entry.Record.Subject, entry.Record.Grade)
+	if r := []rune(s); len(r) > 44 {
+		s = string(r[:41]) + "..."
+	}
+	return s
+}
+
+func note(entry *DiffEntry) string {
+	if entry == nil {
+		return ""
+	}
+	switch entry.Status {
+	case StatusMoved:
+		return fmt.Sprintf("[also at #%d]", entry.Other)
+	case StatusConflict:
+		return fmt.Sprintf("[CONFLICT with #%d]", entry.Other)
+	}
+	return "[only here]"
+}
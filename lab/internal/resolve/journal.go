@@ -0,0 +1,323 @@
+package resolve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// JournalFile is where every resolve records the reorganization it made.
+const JournalFile = "reorgs.json"
+
+// Reorg is one journal entry: a resolve that rewrote Winner and every chain
+// in Losers to the same blocks. The losers' previous chains stay in the
+// block store so that Undo can bring them back.
+type Reorg struct {
+	ID           int         `json:"id"`
+	Time         int64       `json:"time"`
+	Winner       string      `json:"winner"`
+	WinnerLength int         `json:"winner_length"`
+	Losers       []*Orphaned `json:"losers"`
+	Replayed     []string    `json:"replayed"`
+	ResultTip    string      `json:"result_tip"`
+	ResultLength int         `json:"result_length"`
+	UndoneAt     int64       `json:"undone_at,omitempty"`
+}
+
+// Orphaned describes what a resolve took away from one losing chain.
+// Blocks lists the hashes past the common ancestor, which are no longer
+// part of the chain; Tip is where the chain ended, in the block store.
+// Entries written before the block store have an Archive instead, relative
+// to the data directory, holding the whole chain as it was.
+type Orphaned struct {
+	Chain    string   `json:"chain"`
+	Ancestor int      `json:"common_ancestor"`
+	Length   int      `json:"length"`
+	Blocks   []string `json:"orphaned_blocks"`
+	Tip      string   `json:"tip,omitempty"`
+	Archive  string   `json:"archive,omitempty"`
+}
+
+type Journal struct {
+	Reorgs []*Reorg `json:"reorgs"`
+}
+
+// LoadJournal reads the journal; a missing file is an empty journal.
+func LoadJournal(filename string) (*Journal, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Journal{}, nil
+		}
+		return nil, err
+	}
+
+	var j Journal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	return &j, nil
+}
+
+func (j *Journal) Save(filename string) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0o644)
+}
+
+func (j *Journal) Get(id int) (*Reorg, error) {
+	for _, r := range j.Reorgs {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("no reorg #%d in the journal", id)
+}
+
+func (j *Journal) nextID() int {
+	if len(j.Reorgs) == 0 {
+		return 1
+	}
+	return j.Reorgs[len(j.Reorgs)-1].ID + 1
+}
+
+// beginReorg keeps every loser of the plans in the block store before
+// anything is overwritten and returns the journal entry to be completed by
+// endReorg.
+func (m *Manager) beginReorg(plans []*Plan) (*Journal, *Reorg, error) {
+	j, err := LoadJournal(m.journalFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &Reorg{
+		ID:           j.nextID(),
+		Winner:       plans[0].Winner,
+		WinnerLength: plans[0].WinnerLength,
+		Replayed:     []string{},
+	}
+
+	for _, plan := range plans {
+		orphaned := &Orphaned{
+			Chain:    plan.Loser,
+			Ancestor: plan.CommonAncestor,
+			Length:   plan.LoserLength,
+			Blocks:   []string{},
+			Tip:      plan.loserTip,
+		}
+		for _, block := range plan.loser.Blocks()[plan.CommonAncestor+1:] {
+			orphaned.Blocks = append(orphaned.Blocks, block.Hash)
+		}
+
+		if err := m.forkMgr.Keep(plan.loser.Blocks()...); err != nil {
+			return nil, nil, fmt.Errorf("failed to keep chain '%s': %w", plan.Loser, err)
+		}
+		r.Losers = append(r.Losers, orphaned)
+
+		for _, planned := range plan.Replay {
+			r.Replayed = append(r.Replayed, planned.Record.ID)
+		}
+	}
+	return j, r, nil
+}
+
+// endReorg records the finished resolve in the journal.
+func (m *Manager) endReorg(j *Journal, r *Reorg, plans []*Plan) error {
+	winner := plans[0].winner
+	r.Time = time.Now().Unix()
+	r.ResultTip = tip(winner)
+	r.ResultLength = winner.Length()
+
+	for _, plan := range plans {
+		plan.Reorg = r.ID
+	}
+
+	j.Reorgs = append(j.Reorgs, r)
+	if err := j.Save(m.journalFile); err != nil {
+		return fmt.Errorf("chains resolved but failed to write the reorg journal: %w", err)
+	}
+
+	for _, e := range r.Events(winner.Blocks()[r.ResultLength-1]) {
+		m.events.Publish(e)
+	}
+	return nil
+}
+
+// Events describes the reorg as one EventChainResolved per chain it
+// rewrote; tip is the block every one of them now ends with.
+func (r *Reorg) Events(tip *blockchain.Block) []blockchain.Event {
+	chains := []string{r.Winner}
+	for _, loser := range r.Losers {
+		chains = append(chains, loser.Chain)
+	}
+
+	events := make([]blockchain.Event, 0, len(chains))
+	for _, chain := range chains {
+		events = append(events, blockchain.Event{
+			Type:   blockchain.EventChainResolved,
+			Chain:  chain,
+			Height: r.ResultLength - 1,
+			Time:   r.Time,
+			Block:  tip,
+			Winner: r.Winner,
+			Chains: chains,
+			Reorg:  r.ID,
+		})
+	}
+	return events
+}
+
+// Reorgs returns the journal of past resolves.
+func (m *Manager) Reorgs() (*Journal, error) {
+	return LoadJournal(m.journalFile)
+}
+
+// KeptTips returns the tips of the losers that reorgs not yet undone keep in
+// the block store for Undo.
+func (m *Manager) KeptTips() ([]string, error) {
+	j, err := LoadJournal(m.journalFile)
+	if err != nil {
+		return nil, err
+	}
+	var tips []string
+	for _, r := range j.Reorgs {
+		if r.UndoneAt != 0 {
+			continue
+		}
+		for _, orphaned := range r.Losers {
+			if orphaned.Tip != "" {
+				tips = append(tips, orphaned.Tip)
+			}
+		}
+	}
+	return tips, nil
+}
+
+// Undo restores the chains of a reorg to their state before it. It refuses
+// when any of them has changed since, as that work would be lost.
+func (m *Manager) Undo(id int) error {
+	j, err := LoadJournal(m.journalFile)
+	if err != nil {
+		return err
+	}
+	r, err := j.Get(id)
+	if err != nil {
+		return err
+	}
+	if r.UndoneAt != 0 {
+		return fmt.Errorf("reorg #%d was already undone", id)
+	}
+
+	chains := []string{r.Winner}
+	for _, orphaned := range r.Losers {
+		chains = append(chains, orphaned.Chain)
+	}
+	for _, name := range chains {
+		if info, ok := m.forkMgr.Config.GetChain(name); ok && storage.IsArchive(info.File) {
+			return fmt.Errorf("chain '%s' is archived; unarchive it before undoing reorg #%d", name, id)
+		}
+		bc, err := m.load(name)
+		if err != nil {
+			return err
+		}
+		if tip(bc) != r.ResultTip {
+			return fmt.Errorf("chain '%s' has changed since reorg #%d; undoing it would lose the newer blocks", name, id)
+		}
+	}
+
+	winnerStorage, err := m.forkMgr.Storage(r.Winner)
+	if err != nil {
+		return err
+	}
+	winner, err := m.load(r.Winner)
+	if err != nil {
+		return err
+	}
+	before, err := winner.Prefix(r.WinnerLength - 1)
+	if err != nil {
+		return err
+	}
+	if err := m.forkMgr.CheckReorg(r.Winner, winner, r.WinnerLength-1); err != nil {
+		return err
+	}
+
+	// Losers are rebuilt in full before the first chain is rewritten.
+	archived := make([]*blockchain.Blockchain, len(r.Losers))
+	for i, orphaned := range r.Losers {
+		bc, err := m.orphan(orphaned)
+		if err != nil {
+			return fmt.Errorf("failed to restore '%s': %w", orphaned.Chain, err)
+		}
+		if bc == nil || bc.Length() != orphaned.Length {
+			return fmt.Errorf("stored copy of '%s' does not hold its %d blocks", orphaned.Chain, orphaned.Length)
+		}
+		archived[i] = bc
+
+		current, err := m.load(orphaned.Chain)
+		if err != nil {
+			return err
+		}
+		if err := m.forkMgr.CheckReorg(orphaned.Chain, current, m.forkMgr.FindCommonAncestor(current, bc)); err != nil {
+			return err
+		}
+	}
+
+	for i, orphaned := range r.Losers {
+		store, err := m.forkMgr.Storage(orphaned.Chain)
+		if err != nil {
+			return err
+		}
+		if err := store.Save(archived[i]); err != nil {
+			return fmt.Errorf("failed to restore chain '%s': %w", orphaned.Chain, err)
+		}
+	}
+
+	if err := winnerStorage.Save(before); err != nil {
+		return fmt.Errorf("failed to restore chain '%s': %w", r.Winner, err)
+	}
+
+	r.UndoneAt = time.Now().Unix()
+	return j.Save(m.journalFile)
+}
+
+// orphan rebuilds a loser as it was before the reorg.
+func (m *Manager) orphan(orphaned *Orphaned) (*blockchain.Blockchain, error) {
+	if orphaned.Tip != "" {
+		return m.forkMgr.ChainAt(orphaned.Tip)
+	}
+	return storage.NewGzipStorage(m.forkMgr.Path(orphaned.Archive)).Load()
+}
+
+// Print lists the journal, newest first.
+func (j *Journal) Print(w io.Writer) {
+	if len(j.Reorgs) == 0 {
+		fmt.Fprintln(w, "No reorganizations recorded.")
+		return
+	}
+
+	for i := len(j.Reorgs) - 1; i >= 0; i-- {
+		r := j.Reorgs[i]
+		status := ""
+		if r.UndoneAt != 0 {
+			status = fmt.Sprintf(" [undone %s]", time.Unix(r.UndoneAt, 0).Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintf(w, "#%d %s winner '%s' (%d -> %d blocks), %d record(s) replayed%s\n",
+			r.ID, time.Unix(r.Time, 0).Format("2006-01-02 15:04:05"),
+			r.Winner, r.WinnerLength, r.ResultLength, len(r.Replayed), status)
+		for _, orphaned := range r.Losers {
+			kept := "tip " + orphaned.Tip
+			if orphaned.Tip == "" {
+				kept = "archived in " + orphaned.Archive
+			}
+			fmt.Fprintf(w, "    '%s': ancestor #%d, %d block(s) orphaned, %s\n",
+				orphaned.Chain, orphaned.Ancestor, len(orphaned.Blocks), kept)
+		}
+	}
+}
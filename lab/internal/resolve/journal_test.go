@@ -0,0 +1,86 @@
+package resolve
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUndoRestoresEveryChain(t *testing.T) {
+	m := newTestManager(t)
+	forkChains(t, m, "a", "b")
+	addRecords(t, m, "a", "a1")
+	addRecords(t, m, "b", "b1", "b2")
+
+	chains := []string{"main", "a", "b"}
+	tips := make(map[string]string)
+	records := make(map[string][]string)
+	for _, chain := range chains {
+		tips[chain] = tipOf(t, m, chain)
+		records[chain] = recordNames(t, m, chain)
+	}
+
+	if err := m.ResolveAll(chains, Options{Policy: PolicyWinner}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Undo(1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chain := range chains {
+		if got := tipOf(t, m, chain); got != tips[chain] {
+			t.Errorf("'%s' ends at %s after undo, want %s", chain, got, tips[chain])
+		}
+		if got := recordNames(t, m, chain); !reflect.DeepEqual(got, records[chain]) {
+			t.Errorf("'%s' holds %v after undo, want %v", chain, got, records[chain])
+		}
+	}
+
+	j, err := m.Reorgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Reorgs[0].UndoneAt == 0 {
+		t.Error("reorg #1 is not marked undone")
+	}
+	if err := m.Undo(1); err == nil || !strings.Contains(err.Error(), "already undone") {
+		t.Errorf("undoing reorg #1 twice: %v, want an already undone error", err)
+	}
+
+	// The chains can be resolved again after the undo.
+	if err := m.Resolve("a", "b", Options{Policy: PolicyWinner}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"base", "b1", "b2", "a1"}; !reflect.DeepEqual(recordNames(t, m, "a"), want) {
+		t.Errorf("'a' holds %v after resolving again, want %v", recordNames(t, m, "a"), want)
+	}
+}
+
+func TestUndoRefusesChangedChains(t *testing.T) {
+	m := newTestManager(t)
+	forkChains(t, m, "a")
+	addRecords(t, m, "a", "a1")
+	addRecords(t, m, "main", "m1", "m2")
+
+	if err := m.Resolve("main", "a", Options{Policy: PolicyWinner}); err != nil {
+		t.Fatal(err)
+	}
+	addRecords(t, m, "a", "later")
+	before := tipOf(t, m, "a")
+
+	err := m.Undo(1)
+	if err == nil || !strings.Contains(err.Error(), "has changed since reorg #1") {
+		t.Fatalf("undo after a new block: %v, want a changed chain error", err)
+	}
+	if got := tipOf(t, m, "a"); got != before {
+		t.Errorf("refused undo moved 'a' from %s to %s", before, got)
+	}
+
+	j, err := m.Reorgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Reorgs[0].UndoneAt != 0 {
+		t.Error("refused undo marked reorg #1 undone")
+	}
+}
@@ -2,163 +2,211 @@ package resolve
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
 	"github.com/rx3lixir/lab_bc/internal/fork"
-	"github.com/rx3lixir/lab_bc/internal/storage"
 )
 
 type Manager struct {
-	forkMgr *fork.Manager
+	forkMgr     *fork.Manager
+	journalFile string
+	events      *blockchain.Bus
+	out         io.Writer
 }
 
 func NewManager(forkMgr *fork.Manager) *Manager {
-	return &Manager{forkMgr: forkMgr}
+	return &Manager{forkMgr: forkMgr, journalFile: forkMgr.Path(JournalFile), out: os.Stdout}
+}
+
+// SetOutput makes the manager report what it does to w rather than to
+// standard output.
+func (m *Manager) SetOutput(w io.Writer) {
+	m.out = w
+}
+
+// Publish makes the manager announce resolves and failed validations on
+// bus.
+func (m *Manager) Publish(bus *blockchain.Bus) {
+	m.events = bus
+}
+
+// validationFailed publishes err as the validation failure of chain and
+// returns it.
+func (m *Manager) validationFailed(chain string, bc *blockchain.Blockchain, err error) error {
+	m.events.Publish(blockchain.Event{
+		Type:   blockchain.EventValidationFailed,
+		Chain:  chain,
+		Height: bc.Length() - 1,
+		Error:  err.Error(),
+	})
+	return err
 }
 
 func (m *Manager) Validate(chain1Name, chain2Name string) error {
-	file1, err := m.forkMgr.GetChainFile(chain1Name)
+	bc1, bc2, commonAncestor, err := m.Check(chain1Name, chain2Name)
 	if err != nil {
 		return err
 	}
 
-	file2, err := m.forkMgr.GetChainFile(chain2Name)
+	fmt.Fprintf(m.out, "✓ Both chains are valid\n")
+	fmt.Fprintf(m.out, "✓ Common ancestor found at block #%d\n", commonAncestor)
+	fmt.Fprintf(m.out, "  Chain '%s': %d blocks\n", chain1Name, bc1.Length())
+	fmt.Fprintf(m.out, "  Chain '%s': %d blocks\n", chain2Name, bc2.Length())
+
+	return nil
+}
+
+// Check validates two chains as a resolve of them would: each chain and its
+// checkpoints, their common ancestor, and that the chain the fork-choice
+// rule makes the loser may be rolled back to it. It returns the chains and
+// their common ancestor.
+func (m *Manager) Check(chain1Name, chain2Name string) (*blockchain.Blockchain, *blockchain.Blockchain, int, error) {
+	storage1, err := m.forkMgr.Storage(chain1Name)
 	if err != nil {
-		return err
+		return nil, nil, -1, err
+	}
+
+	storage2, err := m.forkMgr.Storage(chain2Name)
+	if err != nil {
+		return nil, nil, -1, err
 	}
 
-	storage1 := storage.NewJSONStorage(file1)
 	bc1, err := storage1.Load()
 	if err != nil {
-		return fmt.Errorf("failed to load chain '%s': %w", chain1Name, err)
+		return nil, nil, -1, fmt.Errorf("failed to load chain '%s': %w", chain1Name, err)
 	}
 
-	storage2 := storage.NewJSONStorage(file2)
 	bc2, err := storage2.Load()
 	if err != nil {
-		return fmt.Errorf("failed to load chain '%s': %w", chain2Name, err)
+		return nil, nil, -1, fmt.Errorf("failed to load chain '%s': %w", chain2Name, err)
 	}
 
 	if err := bc1.Validate(); err != nil {
-		return fmt.Errorf("chain '%s' validation failed: %w", chain1Name, err)
+		return nil, nil, -1, m.validationFailed(chain1Name, bc1, fmt.Errorf("chain '%s' validation failed: %w", chain1Name, err))
 	}
 
 	if err := bc2.Validate(); err != nil {
-		return fmt.Errorf("chain '%s' validation failed: %w", chain2Name, err)
+		return nil, nil, -1, m.validationFailed(chain2Name, bc2, fmt.Errorf("chain '%s' validation failed: %w", chain2Name, err))
+	}
+
+	if err := m.forkMgr.CheckCheckpoints(chain1Name, bc1); err != nil {
+		return nil, nil, -1, m.validationFailed(chain1Name, bc1, err)
+	}
+	if err := m.forkMgr.CheckCheckpoints(chain2Name, bc2); err != nil {
+		return nil, nil, -1, m.validationFailed(chain2Name, bc2, err)
 	}
 
-	commonAncestor := m.forkMgr.FindCommonAncestor(bc1, bc2)
+	commonAncestor := m.forkMgr.CommonAncestor(chain1Name, bc1, chain2Name, bc2)
 	if commonAncestor == -1 {
-		return fmt.Errorf("chains have no common ancestor")
+		return nil, nil, -1, fmt.Errorf("chains have no common ancestor")
 	}
 
-	fmt.Printf("✓ Both chains are valid\n")
-	fmt.Printf("✓ Common ancestor found at block #%d\n", commonAncestor)
-	fmt.Printf("  Chain '%s': %d blocks\n", chain1Name, bc1.Length())
-	fmt.Printf("  Chain '%s': %d blocks\n", chain2Name, bc2.Length())
+	loserName, loser := chain2Name, bc2
+	if !m.chooseFirst(chain1Name, chain2Name, bc1, bc2, &Plan{}) {
+		loserName, loser = chain1Name, bc1
+	}
+	if err := m.forkMgr.CheckReorg(loserName, loser, commonAncestor); err != nil {
+		return nil, nil, -1, m.validationFailed(loserName, loser, err)
+	}
 
-	return nil
+	return bc1, bc2, commonAncestor, nil
 }
 
-func (m *Manager) Resolve(chain1Name, chain2Name string) error {
-	file1, err := m.forkMgr.GetChainFile(chain1Name)
+// Resolve merges two chains: the loser's unique records are replayed on top
+// of the winner and both chains end up with the winner's blocks. Under
+// PolicyManual undecided conflicts are written to a conflict file instead,
+// and a later Resolve picks up the choices made there.
+func (m *Manager) Resolve(chain1Name, chain2Name string, opts Options) error {
+	plan, err := m.Plan(chain1Name, chain2Name, opts)
 	if err != nil {
 		return err
 	}
 
-	file2, err := m.forkMgr.GetChainFile(chain2Name)
-	if err != nil {
-		return err
+	if n := plan.Undecided(); n > 0 {
+		if err := plan.writeConflicts(); err != nil {
+			return fmt.Errorf("failed to write conflict file: %w", err)
+		}
+		return fmt.Errorf("%d conflicting grade(s) need a manual choice: set \"choice\" to \"winner\" or \"loser\" in %s and run -resolve again",
+			n, plan.ConflictFile)
 	}
 
-	storage1 := storage.NewJSONStorage(file1)
-	bc1, err := storage1.Load()
-	if err != nil {
-		return fmt.Errorf("failed to load chain '%s': %w", chain1Name, err)
-	}
+	fmt.Fprintf(m.out, "Winner: '%s' (%d blocks)\n", plan.Winner, plan.WinnerLength)
+	fmt.Fprintf(m.out, "Loser: '%s' (%d blocks)\n", plan.Loser, plan.LoserLength)
 
-	storage2 := storage.NewJSONStorage(file2)
-	bc2, err := storage2.Load()
-	if err != nil {
-		return fmt.Errorf("failed to load chain '%s': %w", chain2Name, err)
+	if err := m.Apply(plan); err != nil {
+		return err
 	}
 
-	commonAncestor := m.forkMgr.FindCommonAncestor(bc1, bc2)
-	if commonAncestor == -1 {
-		return fmt.Errorf("chains have no common ancestor - cannot resolve")
-	}
-
-	var winner, loser *blockchain.Blockchain
-	var winnerName, loserName string
-	var winnerStorage, loserStorage *storage.JSONStorage
-
-	info1, _ := m.forkMgr.Config.GetChain(chain1Name)
-	info2, _ := m.forkMgr.Config.GetChain(chain2Name)
-
-	if bc1.Length() > bc2.Length() {
-		winner, loser = bc1, bc2
-		winnerName, loserName = chain1Name, chain2Name
-		winnerStorage, loserStorage = storage1, storage2
-	} else if bc2.Length() > bc1.Length() {
-		winner, loser = bc2, bc1
-		winnerName, loserName = chain2Name, chain1Name
-		winnerStorage, loserStorage = storage2, storage1
-	} else {
-		if info1.ForkFrom == nil {
-			winner, loser = bc1, bc2
-			winnerName, loserName = chain1Name, chain2Name
-			winnerStorage, loserStorage = storage1, storage2
-		} else if info2.ForkFrom == nil {
-			winner, loser = bc2, bc1
-			winnerName, loserName = chain2Name, chain1Name
-			winnerStorage, loserStorage = storage2, storage1
-		} else if *info1.ForkFrom == chain2Name {
-			winner, loser = bc2, bc1
-			winnerName, loserName = chain2Name, chain1Name
-			winnerStorage, loserStorage = storage2, storage1
-		} else {
-			winner, loser = bc1, bc2
-			winnerName, loserName = chain1Name, chain2Name
-			winnerStorage, loserStorage = storage1, storage2
-		}
+	fmt.Fprintf(m.out, "✓ Resolve complete (reorg #%d, undo with -undo-resolve %d)\n", plan.Reorg, plan.Reorg)
+	fmt.Fprintf(m.out, "  Added %d unique records from '%s' to '%s'\n", len(plan.Replay), plan.Loser, plan.Winner)
+	if len(plan.Conflicts) > 0 {
+		fmt.Fprintf(m.out, "  Settled %d conflicting grade(s) by policy '%s'\n", len(plan.Conflicts), plan.Policy)
 	}
+	fmt.Fprintf(m.out, "  Both chains now have %d blocks\n", plan.ResultLength)
 
-	fmt.Printf("Winner: '%s' (%d blocks)\n", winnerName, winner.Length())
-	fmt.Printf("Loser: '%s' (%d blocks)\n", loserName, loser.Length())
+	return nil
+}
 
-	loserBlocks := loser.Blocks()
-	winnerBlocks := winner.Blocks()
+// Apply carries out a plan produced by Plan.
+func (m *Manager) Apply(plan *Plan) error {
+	if n := plan.Undecided(); n > 0 {
+		return fmt.Errorf("plan has %d undecided conflict(s)", n)
+	}
 
-	existingIDs := make(map[string]bool)
-	for _, block := range winnerBlocks {
-		if block.Data.ID != "" {
-			existingIDs[block.Data.ID] = true
-		}
+	j, reorg, err := m.beginReorg([]*Plan{plan})
+	if err != nil {
+		return err
 	}
 
-	addedCount := 0
-	for i := commonAncestor + 1; i < len(loserBlocks); i++ {
-		record := loserBlocks[i].Data
-		if record.ID == "" || !existingIDs[record.ID] {
-			if _, err := winner.AddBlock(record); err != nil {
-				return fmt.Errorf("failed to add block from loser chain: %w", err)
-			}
-			existingIDs[record.ID] = true
-			addedCount++
-		}
+	if err := plan.replay(time.Now().Unix()); err != nil {
+		return err
 	}
 
-	if err := winnerStorage.Save(winner); err != nil {
+	if err := plan.winnerStorage.Save(plan.winner); err != nil {
 		return fmt.Errorf("failed to save winner chain: %w", err)
 	}
 
-	if err := loserStorage.Save(winner); err != nil {
+	if err := plan.loserStorage.Save(plan.winner); err != nil {
 		return fmt.Errorf("failed to save loser chain: %w", err)
 	}
 
-	fmt.Printf("✓ Resolve complete\n")
-	fmt.Printf("  Added %d unique records from '%s' to '%s'\n", addedCount, loserName, winnerName)
-	fmt.Printf("  Both chains now have %d blocks\n", winner.Length())
+	if plan.ConflictFile != "" {
+		if err := os.Remove(plan.ConflictFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("chains resolved but failed to remove %s: %w", plan.ConflictFile, err)
+		}
+	}
 
+	return m.endReorg(j, reorg, []*Plan{plan})
+}
+
+// replay mines the plan's records onto the winner in memory.
+func (p *Plan) replay(resolvedAt int64) error {
+	for _, planned := range p.Replay {
+		if _, err := p.winner.AddBlock(replayed(planned, p.Loser, p.Winner, resolvedAt)); err != nil {
+			return fmt.Errorf("failed to add block from '%s': %w", p.Loser, err)
+		}
+	}
 	return nil
 }
+
+// replayed is the record as it is re-mined from chain from into chain into:
+// its original issue time is kept and the block it leaves behind is added
+// to its provenance.
+func replayed(planned PlannedRecord, from, into string, resolvedAt int64) blockchain.StudentRecord {
+	record := planned.Record
+	record.IssuedAt = planned.issuedAt()
+
+	provenance := make([]blockchain.Hop, len(record.Provenance), len(record.Provenance)+1)
+	copy(provenance, record.Provenance)
+	record.Provenance = append(provenance, blockchain.Hop{
+		Chain:      from,
+		Block:      planned.Block,
+		Hash:       planned.Hash,
+		Into:       into,
+		ResolvedAt: resolvedAt,
+	})
+	return record
+}
@@ -0,0 +1,270 @@
+package resolve
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+// Plan is everything a resolve would do, computed without writing.
+type Plan struct {
+	Winner         string          `json:"winner"`
+	Loser          string          `json:"loser"`
+	Reason         string          `json:"reason"`
+	WinnerLength   int             `json:"winner_length"`
+	LoserLength    int             `json:"loser_length"`
+	CommonAncestor int             `json:"common_ancestor"`
+	Replay         []PlannedRecord `json:"replay"`
+	Skipped        []PlannedRecord `json:"skipped"`
+	Policy         Policy          `json:"policy"`
+	Conflicts      []*Conflict     `json:"conflicts"`
+	ConflictFile   string          `json:"conflict_file,omitempty"`
+	ResultLength   int             `json:"result_length"`
+	Reorg          int             `json:"reorg,omitempty"`
+
+	winner, loser               *blockchain.Blockchain
+	winnerStorage, loserStorage storage.Storage
+	// Tips as loaded; they pin a conflict file to the chains it was
+	// written for.
+	winnerTip, loserTip string
+	// participants lists every chain of an N-way resolve.
+	participants []string
+}
+
+// PlannedRecord is a loser record together with the block it came from.
+type PlannedRecord struct {
+	Block     int                      `json:"block"`
+	Hash      string                   `json:"hash"`
+	Timestamp int64                    `json:"timestamp"`
+	Record    blockchain.StudentRecord `json:"record"`
+}
+
+func plannedRecord(block *blockchain.Block) PlannedRecord {
+	return PlannedRecord{
+		Block:     block.Index,
+		Hash:      block.Hash,
+		Timestamp: block.Timestamp,
+		Record:    block.Data,
+	}
+}
+
+// Plan picks the winner by the fork-choice rule and lists which loser
+// records would be replayed onto it. Loser records that grade a student
+// differently from the winner's branch are settled by opts.Policy.
+func (m *Manager) Plan(chain1Name, chain2Name string, opts Options) (*Plan, error) {
+	storage1, err := m.forkMgr.Storage(chain1Name)
+	if err != nil {
+		return nil, err
+	}
+
+	storage2, err := m.forkMgr.Storage(chain2Name)
+	if err != nil {
+		return nil, err
+	}
+
+	bc1, err := storage1.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain '%s': %w", chain1Name, err)
+	}
+
+	bc2, err := storage2.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain '%s': %w", chain2Name, err)
+	}
+
+	plan := &Plan{}
+	if m.chooseFirst(chain1Name, chain2Name, bc1, bc2, plan) {
+		plan.Winner, plan.Loser = chain1Name, chain2Name
+		plan.winner, plan.loser = bc1, bc2
+		plan.winnerStorage, plan.loserStorage = storage1, storage2
+	} else {
+		plan.Winner, plan.Loser = chain2Name, chain1Name
+		plan.winner, plan.loser = bc2, bc1
+		plan.winnerStorage, plan.loserStorage = storage2, storage1
+	}
+	plan.winnerTip = tip(plan.winner)
+
+	if err := m.fill(plan, opts); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// fill works out what replaying plan.loser onto plan.winner involves. The
+// winner may already hold records replayed by earlier plans.
+func (m *Manager) fill(plan *Plan, opts Options) error {
+	commonAncestor := m.forkMgr.CommonAncestor(plan.Winner, plan.winner, plan.Loser, plan.loser)
+	if commonAncestor == -1 {
+		return fmt.Errorf("chains have no common ancestor - cannot resolve")
+	}
+	if err := m.forkMgr.CheckReorg(plan.Loser, plan.loser, commonAncestor); err != nil {
+		return err
+	}
+
+	plan.CommonAncestor = commonAncestor
+	plan.Replay = []PlannedRecord{}
+	plan.Skipped = []PlannedRecord{}
+	plan.Conflicts = []*Conflict{}
+	plan.Policy = opts.Policy
+	if plan.Policy == "" {
+		plan.Policy = PolicyWinner
+	}
+	plan.WinnerLength = plan.winner.Length()
+	plan.LoserLength = plan.loser.Length()
+	plan.loserTip = tip(plan.loser)
+
+	existingIDs := make(map[string]bool)
+	for _, block := range plan.winner.Blocks() {
+		if block.Data.ID != "" {
+			existingIDs[block.Data.ID] = true
+		}
+	}
+
+	// Only grades issued after the fork can contradict each other; the
+	// latest one per key on the winner's side is what a loser record faces.
+	graded := make(map[conflictKey]PlannedRecord)
+	for _, block := range plan.winner.Blocks()[commonAncestor+1:] {
+		if key, ok := keyOf(block.Data); ok {
+			graded[key] = plannedRecord(block)
+		}
+	}
+
+	var candidates []PlannedRecord
+	conflicts := make(map[string]*Conflict)
+	for _, block := range plan.loser.Blocks()[commonAncestor+1:] {
+		planned := plannedRecord(block)
+		if planned.Record.ID != "" && existingIDs[planned.Record.ID] {
+			plan.Skipped = append(plan.Skipped, planned)
+			continue
+		}
+		existingIDs[planned.Record.ID] = true
+		candidates = append(candidates, planned)
+
+		key, ok := keyOf(planned.Record)
+		if !ok {
+			continue
+		}
+		if other, found := graded[key]; found && other.Record.Grade != planned.Record.Grade {
+			conflict := &Conflict{
+				Zachetka: key.zachetka,
+				Subject:  key.subject,
+				Course:   key.course,
+				Winner:   other,
+				Loser:    planned,
+			}
+			plan.Conflicts = append(plan.Conflicts, conflict)
+			conflicts[planned.Hash] = conflict
+		}
+	}
+
+	if plan.Policy == PolicyManual {
+		plan.ConflictFile = m.forkMgr.Path(ConflictFileName(plan.Winner, plan.Loser))
+	}
+	if err := m.decide(plan, opts); err != nil {
+		return err
+	}
+
+	// A loser record whose conflict went to the winner, or is undecided, is
+	// not replayed. One that won is replayed after the winner's grade and so
+	// supersedes it.
+	for _, planned := range candidates {
+		if c := conflicts[planned.Hash]; c != nil && c.Choice != ChoiceLoser {
+			continue
+		}
+		plan.Replay = append(plan.Replay, planned)
+	}
+
+	plan.ResultLength = plan.WinnerLength + len(plan.Replay)
+	return nil
+}
+
+func tip(bc *blockchain.Blockchain) string {
+	blocks := bc.Blocks()
+	return blocks[len(blocks)-1].Hash
+}
+
+// chooseFirst applies the fork-choice rule: the longer chain wins; on equal
+// length a root chain beats a fork, and a fork loses to its own parent.
+func (m *Manager) chooseFirst(chain1Name, chain2Name string, bc1, bc2 *blockchain.Blockchain, plan *Plan) bool {
+	info1, _ := m.forkMgr.Config.GetChain(chain1Name)
+	info2, _ := m.forkMgr.Config.GetChain(chain2Name)
+
+	switch {
+	case bc1.Length() > bc2.Length():
+		plan.Reason = fmt.Sprintf("longer chain (%d > %d blocks)", bc1.Length(), bc2.Length())
+		return true
+	case bc2.Length() > bc1.Length():
+		plan.Reason = fmt.Sprintf("longer chain (%d > %d blocks)", bc2.Length(), bc1.Length())
+		return false
+	case info1.ForkFrom == nil:
+		plan.Reason = fmt.Sprintf("equal length; '%s' is a root chain", chain1Name)
+		return true
+	case info2.ForkFrom == nil:
+		plan.Reason = fmt.Sprintf("equal length; '%s' is a root chain", chain2Name)
+		return false
+	case *info1.ForkFrom == chain2Name:
+		plan.Reason = fmt.Sprintf("equal length; '%s' was forked from '%s'", chain1Name, chain2Name)
+		return false
+	default:
+		plan.Reason = fmt.Sprintf("equal length; '%s' named first", chain1Name)
+		return true
+	}
+}
+
+// Print writes the plan in human-readable form.
+func (p *Plan) Print(w io.Writer) {
+	fmt.Fprintf(w, "=== Resolve plan (dry run) ===\n")
+	fmt.Fprintf(w, "Winner: '%s' (%d blocks) - %s\n", p.Winner, p.WinnerLength, p.Reason)
+	fmt.Fprintf(w, "Loser:  '%s' (%d blocks)\n", p.Loser, p.LoserLength)
+	fmt.Fprintf(w, "Common ancestor: block #%d\n\n", p.CommonAncestor)
+
+	fmt.Fprintf(w, "Records to replay onto '%s': %d\n", p.Winner, len(p.Replay))
+	for _, r := range p.Replay {
+		printPlanned(w, r)
+	}
+
+	fmt.Fprintf(w, "\nRecords skipped as duplicates: %d\n", len(p.Skipped))
+	for _, r := range p.Skipped {
+		printPlanned(w, r)
+	}
+
+	fmt.Fprintf(w, "\nConflicting grades (policy %s): %d\n", p.Policy, len(p.Conflicts))
+	for _, c := range p.Conflicts {
+		choice := c.Choice
+		if choice == "" {
+			choice = "undecided"
+		}
+		fmt.Fprintf(w, "  %s | %s | course %d: '%s' grade %d vs '%s' grade %d -> %s\n",
+			c.Zachetka, c.Subject, c.Course,
+			p.Winner, c.Winner.Record.Grade, p.Loser, c.Loser.Record.Grade, choice)
+	}
+	if n := p.Undecided(); n > 0 {
+		fmt.Fprintf(w, "  %d conflict(s) need a manual choice in %s\n", n, p.ConflictFile)
+	}
+
+	fmt.Fprintf(w, "\nResult: both chains would have %d blocks\n", p.ResultLength)
+	fmt.Fprintf(w, "Nothing was written.\n")
+}
+
+// issuedAt falls back to the block time for records written before
+// StudentRecord carried its own.
+func (r PlannedRecord) issuedAt() int64 {
+	if r.Record.IssuedAt != 0 {
+		return r.Record.IssuedAt
+	}
+	return r.Timestamp
+}
+
+func keyOf(record blockchain.StudentRecord) (conflictKey, bool) {
+	if record.Zachetka == "" || record.Subject == "" {
+		return conflictKey{}, false
+	}
+	return conflictKey{record.Zachetka, record.Subject, record.Course}, true
+}
+
+func printPlanned(w io.Writer, r PlannedRecord) {
+	fmt.Fprintf(w, "  #%d %s | %s | %s | grade %d (id %s)\n",
+		r.Block, r.Record.FullName, r.Record.Zachetka, r.Record.Subject, r.Record.Grade, r.Record.ID)
+}
@@ -0,0 +1,154 @@
+package resolve
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Rebase is the outcome of replaying a chain's own records onto another
+// chain's tip. Only Chain is rewritten.
+type Rebase struct {
+	Chain          string          `json:"chain"`
+	Onto           string          `json:"onto"`
+	CommonAncestor int             `json:"common_ancestor"`
+	OldLength      int             `json:"old_length"`
+	OldTip         string          `json:"old_tip"`
+	NewLength      int             `json:"new_length"`
+	NewTip         string          `json:"new_tip"`
+	Moved          []*MovedBlock   `json:"moved"`
+	Skipped        []PlannedRecord `json:"skipped"`
+}
+
+// MovedBlock maps a block of the chain before the rebase to the block its
+// record was re-mined into.
+type MovedBlock struct {
+	ID       string `json:"id"`
+	OldBlock int    `json:"old_block"`
+	OldHash  string `json:"old_hash"`
+	NewBlock int    `json:"new_block"`
+	NewHash  string `json:"new_hash"`
+}
+
+// Rebase re-mines the records name added after its common ancestor with
+// onto on top of onto's tip; records onto already holds, by ID, are
+// skipped. name becomes a fork of onto at that tip. Its old blocks stay in
+// the block store.
+func (m *Manager) Rebase(name, onto string) (*Rebase, error) {
+	if name == onto {
+		return nil, fmt.Errorf("cannot rebase '%s' onto itself", name)
+	}
+	if err := m.forkMgr.CheckParent(name, onto); err != nil {
+		return nil, err
+	}
+
+	bc, err := m.load(name)
+	if err != nil {
+		return nil, err
+	}
+	target, err := m.load(onto)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestor := m.forkMgr.CommonAncestor(name, bc, onto, target)
+	if ancestor == -1 {
+		return nil, fmt.Errorf("chains have no common ancestor - cannot rebase")
+	}
+
+	result := &Rebase{
+		Chain:          name,
+		Onto:           onto,
+		CommonAncestor: ancestor,
+		OldLength:      bc.Length(),
+		OldTip:         tip(bc),
+		Moved:          []*MovedBlock{},
+		Skipped:        []PlannedRecord{},
+		NewLength:      bc.Length(),
+		NewTip:         tip(bc),
+	}
+	if ancestor == target.Length()-1 {
+		return result, nil
+	}
+	if err := m.forkMgr.CheckReorg(name, bc, ancestor); err != nil {
+		return nil, err
+	}
+
+	existingIDs := make(map[string]bool)
+	for _, block := range target.Blocks() {
+		if block.Data.ID != "" {
+			existingIDs[block.Data.ID] = true
+		}
+	}
+
+	rebased, err := target.Prefix(target.Length() - 1)
+	if err != nil {
+		return nil, err
+	}
+	rebasedAt := time.Now().Unix()
+
+	for _, block := range bc.Blocks()[ancestor+1:] {
+		planned := plannedRecord(block)
+		if planned.Record.ID != "" && existingIDs[planned.Record.ID] {
+			result.Skipped = append(result.Skipped, planned)
+			continue
+		}
+		existingIDs[planned.Record.ID] = true
+
+		if _, err := rebased.AddBlock(replayed(planned, name, name, rebasedAt)); err != nil {
+			return nil, fmt.Errorf("failed to re-mine block #%d: %w", block.Index, err)
+		}
+		mined := rebased.Blocks()[rebased.Length()-1]
+		result.Moved = append(result.Moved, &MovedBlock{
+			ID:       planned.Record.ID,
+			OldBlock: block.Index,
+			OldHash:  block.Hash,
+			NewBlock: mined.Index,
+			NewHash:  mined.Hash,
+		})
+	}
+
+	store, err := m.forkMgr.Storage(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Save(rebased); err != nil {
+		return nil, fmt.Errorf("failed to save chain '%s': %w", name, err)
+	}
+	if err := m.forkMgr.SetParent(name, onto); err != nil {
+		return nil, fmt.Errorf("chain '%s' rebased but not re-parented: %w", name, err)
+	}
+
+	result.NewLength = rebased.Length()
+	result.NewTip = tip(rebased)
+	return result, nil
+}
+
+// Print writes the rebase and its old to new block mapping.
+func (r *Rebase) Print(w io.Writer) {
+	if r.OldTip == r.NewTip {
+		fmt.Fprintf(w, "✓ '%s' is already based on the tip of '%s', nothing to rebase\n", r.Chain, r.Onto)
+		return
+	}
+
+	fmt.Fprintf(w, "✓ Rebased '%s' onto '%s' (common ancestor #%d)\n", r.Chain, r.Onto, r.CommonAncestor)
+	fmt.Fprintf(w, "  %d -> %d blocks, %d record(s) re-mined, %d skipped as duplicates\n",
+		r.OldLength, r.NewLength, len(r.Moved), len(r.Skipped))
+
+	for _, moved := range r.Moved {
+		fmt.Fprintf(w, "  #%d %s -> #%d %s (id %s)\n",
+			moved.OldBlock, shortHash(moved.OldHash), moved.NewBlock, shortHash(moved.NewHash), moved.ID)
+	}
+	for _, skipped := range r.Skipped {
+		fmt.Fprintf(w, "  #%d %s skipped, '%s' already has id %s\n",
+			skipped.Block, shortHash(skipped.Hash), r.Onto, skipped.Record.ID)
+	}
+	fmt.Fprintf(w, "  Old tip %s stays in the block store\n", shortHash(r.OldTip))
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 16 {
+		return hash[:16]
+	}
+	return hash
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+const (
+	BlockStoreFile    = "blocks.json"
+	BlockStoreFormat  = "lab-bc/block-store"
+	BlockStoreVersion = 1
+)
+
+// BlockStore holds every known block keyed by hash. Blocks point at their
+// parent through PreviousHash, so the store is a tree: a chain is the path
+// from some block, its tip, back to a genesis block.
+type BlockStore struct {
+	filename string
+	blocks   map[string]*blockchain.Block
+	// quarantined holds blocks read from the file whose content no longer
+	// matches their hash. They are kept, so saving the store loses nothing,
+	// but no chain can be read through them.
+	quarantined map[string]*blockchain.Block
+}
+
+type blockStoreFile struct {
+	Header *blockStoreHeader   `json:"header"`
+	Blocks []*blockchain.Block `json:"blocks"`
+}
+
+type blockStoreHeader struct {
+	Format        string `json:"format"`
+	Version       int    `json:"version"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Difficulty    string `json:"difficulty"`
+}
+
+// OpenBlockStore reads the store; a missing file is an empty store.
+// Every block's hash is checked, since a block whose content no longer
+// matches its hash would silently change every chain running through it.
+// Such blocks are quarantined: only the chains that run through them fail.
+func OpenBlockStore(filename string) (*BlockStore, error) {
+	s := &BlockStore{
+		filename:    filename,
+		blocks:      make(map[string]*blockchain.Block),
+		quarantined: make(map[string]*blockchain.Block),
+	}
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file blockStoreFile
+	if err := decodeStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("malformed block store %s: %w", filename, err)
+	}
+	if file.Header == nil || file.Header.Format != BlockStoreFormat {
+		return nil, fmt.Errorf("%s is not a %s file", filename, BlockStoreFormat)
+	}
+	if file.Header.Version != BlockStoreVersion {
+		return nil, fmt.Errorf("unsupported %s version %d", BlockStoreFormat, file.Header.Version)
+	}
+
+	for _, block := range file.Blocks {
+		if block.Hash != blockchain.CalculateHash(block) {
+			s.quarantined[block.Hash] = block
+			continue
+		}
+		s.blocks[block.Hash] = block
+	}
+	return s, nil
+}
+
+func (s *BlockStore) Filename() string {
+	return s.filename
+}
+
+func (s *BlockStore) Len() int {
+	return len(s.blocks)
+}
+
+func (s *BlockStore) Get(hash string) (*blockchain.Block, bool) {
+	block, ok := s.blocks[hash]
+	return block, ok
+}
+
+// Quarantined returns the hashes of the blocks whose content did not match
+// their hash when the store was read.
+func (s *BlockStore) Quarantined() []string {
+	hashes := make([]string, 0, len(s.quarantined))
+	for hash := range s.quarantined {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// Put adds blocks the store does not have yet and reports how many were new.
+// A block whose content does not match its hash is refused, and none of the
+// blocks are added.
+func (s *BlockStore) Put(blocks ...*blockchain.Block) (int, error) {
+	for _, block := range blocks {
+		if _, ok := s.blocks[block.Hash]; !ok && block.Hash != blockchain.CalculateHash(block) {
+			return 0, fmt.Errorf("block #%d (%s) has an invalid hash", block.Index, block.Hash)
+		}
+	}
+
+	added := 0
+	for _, block := range blocks {
+		if _, ok := s.blocks[block.Hash]; !ok {
+			s.blocks[block.Hash] = block
+			delete(s.quarantined, block.Hash)
+			added++
+		}
+	}
+	return added, nil
+}
+
+// Remove drops the blocks with the given hashes and reports how many the
+// store had.
+func (s *BlockStore) Remove(hashes ...string) int {
+	removed := 0
+	for _, hash := range hashes {
+		if _, ok := s.blocks[hash]; ok {
+			delete(s.blocks, hash)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Chain walks parent pointers from tip back to genesis and returns the
+// blocks in chain order.
+func (s *BlockStore) Chain(tip string) ([]*blockchain.Block, error) {
+	var reversed []*blockchain.Block
+	for hash := tip; ; {
+		block, ok := s.blocks[hash]
+		if _, bad := s.quarantined[hash]; bad {
+			return nil, fmt.Errorf("block %s in %s has an invalid hash and is quarantined", hash, s.filename)
+		}
+		if !ok {
+			return nil, fmt.Errorf("block %s is missing from the block store", hash)
+		}
+		if n := len(reversed); n > 0 && reversed[n-1].Index != block.Index+1 {
+			return nil, fmt.Errorf("block %s does not precede block %s", block.Hash, reversed[n-1].Hash)
+		}
+		reversed = append(reversed, block)
+		if block.Index == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	blocks := make([]*blockchain.Block, len(reversed))
+	for i, block := range reversed {
+		blocks[len(reversed)-1-i] = block
+	}
+	return blocks, nil
+}
+
+// LastValid walks from tip towards genesis and returns the highest block
+// below every quarantined block on the way, with how many blocks lie above
+// it. A chain that runs into a missing block, or whose genesis block is
+// quarantined, has no such block.
+func (s *BlockStore) LastValid(tip string) (string, int, error) {
+	var path []string
+	lowestBad := -1
+	for hash := tip; ; {
+		block, ok := s.blocks[hash]
+		if !ok {
+			if block, ok = s.quarantined[hash]; !ok {
+				return "", 0, fmt.Errorf("block %s is missing from the block store", hash)
+			}
+			lowestBad = len(path)
+		}
+		path = append(path, hash)
+		if block.Index == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	if lowestBad == -1 {
+		return tip, 0, nil
+	}
+	if lowestBad == len(path)-1 {
+		return "", 0, fmt.Errorf("genesis block %s is quarantined; no valid blocks to keep", path[lowestBad])
+	}
+	return path[lowestBad+1], lowestBad + 1, nil
+}
+
+// Find returns the blocks whose hash starts with prefix.
+func (s *BlockStore) Find(prefix string) []*blockchain.Block {
+	var found []*blockchain.Block
+	for hash, block := range s.blocks {
+		if strings.HasPrefix(hash, prefix) {
+			found = append(found, block)
+		}
+	}
+	sortBlocks(found)
+	return found
+}
+
+// Save writes the store through a temporary file, since it holds every
+// chain at once and a torn write would damage all of them.
+func (s *BlockStore) Save() error {
+	blocks := make([]*blockchain.Block, 0, len(s.blocks)+len(s.quarantined))
+	for _, block := range s.blocks {
+		blocks = append(blocks, block)
+	}
+	for _, block := range s.quarantined {
+		blocks = append(blocks, block)
+	}
+	sortBlocks(blocks)
+
+	data, err := json.MarshalIndent(blockStoreFile{
+		Header: &blockStoreHeader{
+			Format:        BlockStoreFormat,
+			Version:       BlockStoreVersion,
+			HashAlgorithm: HashAlgorithm,
+			Difficulty:    blockchain.Difficulty,
+		},
+		Blocks: blocks,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.filename)
+}
+
+func sortBlocks(blocks []*blockchain.Block) {
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].Index != blocks[j].Index {
+			return blocks[i].Index < blocks[j].Index
+		}
+		return blocks[i].Hash < blocks[j].Hash
+	})
+}
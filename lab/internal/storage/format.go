@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+const (
+	FormatName    = "lab-bc/single-record"
+	FormatVersion = 3
+	HashAlgorithm = "sha256"
+)
+
+// Header describes the on-disk layout of a chain file. ChainID is the
+// genesis block hash. Version 2 added Base for fork delta files, version 3
+// the provenance fields of StudentRecord. Deltas are no longer written:
+// forks live in the block store, and old delta files are only read until
+// they are moved there.
+type Header struct {
+	Format        string `json:"format"`
+	Version       int    `json:"version"`
+	ChainID       string `json:"chain_id"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Difficulty    string `json:"difficulty"`
+	Base          *Base  `json:"base,omitempty"`
+}
+
+// Base marks a fork delta file: its blocks continue after block Height of
+// chain Chain, whose hash must be Hash.
+type Base struct {
+	Chain  string `json:"chain"`
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+type chainFile struct {
+	Header *Header             `json:"header"`
+	Blocks []*blockchain.Block `json:"blocks"`
+}
+
+func NewHeader(bc *blockchain.Blockchain) *Header {
+	header := &Header{
+		Format:        FormatName,
+		Version:       FormatVersion,
+		HashAlgorithm: HashAlgorithm,
+		Difficulty:    blockchain.Difficulty,
+	}
+	if blocks := bc.Blocks(); len(blocks) > 0 {
+		header.ChainID = blocks[0].Hash
+	}
+	return header
+}
+
+func (h *Header) Check() error {
+	if h.Format != FormatName {
+		return fmt.Errorf("chain file format is %q, expected %q", h.Format, FormatName)
+	}
+	if h.Version < 1 || h.Version > FormatVersion {
+		return fmt.Errorf("unsupported %s version %d (supported: 1-%d)", FormatName, h.Version, FormatVersion)
+	}
+	if h.HashAlgorithm != HashAlgorithm {
+		return fmt.Errorf("unsupported hash algorithm %q", h.HashAlgorithm)
+	}
+	if h.Difficulty != blockchain.Difficulty {
+		return fmt.Errorf("chain difficulty %q does not match %q", h.Difficulty, blockchain.Difficulty)
+	}
+	return nil
+}
+
+// decodeChain parses a chain file. Files without a header are the
+// unversioned layout with capitalized Go field names; both layouts reject
+// unknown fields so that a file of another format fails loudly instead of
+// decoding into zero values. The returned header is nil for unversioned
+// files.
+func decodeChain(data []byte) (*Header, []*blockchain.Block, error) {
+	var probe struct {
+		Header *Header `json:"header"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, nil, err
+	}
+
+	if probe.Header == nil {
+		blocks, err := decodeLegacy(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unrecognized chain file (not a %s file?): %w", FormatName, err)
+		}
+		return nil, blocks, nil
+	}
+
+	if err := probe.Header.Check(); err != nil {
+		return nil, nil, err
+	}
+
+	var file chainFile
+	if err := decodeStrict(data, &file); err != nil {
+		return nil, nil, fmt.Errorf("malformed %s file: %w", FormatName, err)
+	}
+
+	if file.Header.Base == nil && len(file.Blocks) > 0 && file.Blocks[0].Hash != file.Header.ChainID {
+		return nil, nil, fmt.Errorf("genesis hash does not match chain ID %s", file.Header.ChainID)
+	}
+
+	return file.Header, file.Blocks, nil
+}
+
+type legacyRecord struct {
+	ID       string
+	FullName string
+	Zachetka string
+	Group    string
+	Subject  string
+	Course   int
+	Grade    int
+}
+
+type legacyBlock struct {
+	Index        int
+	Timestamp    int64
+	Data         legacyRecord
+	PreviousHash string
+	Hash         string
+	Nonce        int
+}
+
+func decodeLegacy(data []byte) ([]*blockchain.Block, error) {
+	var file struct {
+		Blocks []*legacyBlock `json:"blocks"`
+	}
+	if err := decodeStrict(data, &file); err != nil {
+		return nil, err
+	}
+
+	blocks := make([]*blockchain.Block, 0, len(file.Blocks))
+	for _, lb := range file.Blocks {
+		blocks = append(blocks, lb.toBlock())
+	}
+	return blocks, nil
+}
+
+func (lb *legacyBlock) toBlock() *blockchain.Block {
+	return &blockchain.Block{
+		Index:     lb.Index,
+		Timestamp: lb.Timestamp,
+		Data: blockchain.StudentRecord{
+			ID:       lb.Data.ID,
+			FullName: lb.Data.FullName,
+			Zachetka: lb.Data.Zachetka,
+			Group:    lb.Data.Group,
+			Subject:  lb.Data.Subject,
+			Course:   lb.Data.Course,
+			Grade:    lb.Data.Grade,
+		},
+		PreviousHash: lb.PreviousHash,
+		Hash:         lb.Hash,
+		Nonce:        lb.Nonce,
+	}
+}
+
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+const ArchiveExt = ".gz"
+
+// archiveFile is the gzip-compressed payload. Chain holds the same document
+// JSONStorage writes; Checksum is the SHA-256 of those bytes.
+type archiveFile struct {
+	Checksum string          `json:"checksum"`
+	Meta     json.RawMessage `json:"meta,omitempty"`
+	Chain    json.RawMessage `json:"chain"`
+}
+
+// GzipStorage reads archived chains. Archives are read-only: Save fails and
+// new archives are written with WriteArchive.
+type GzipStorage struct {
+	filename string
+	verify   bool
+}
+
+func NewGzipStorage(filename string) *GzipStorage {
+	return &GzipStorage{filename: filename}
+}
+
+// Open returns the storage backend matching the file extension.
+func Open(filename string) Storage {
+	if IsArchive(filename) {
+		return NewGzipStorage(filename)
+	}
+	return NewJSONStorage(filename)
+}
+
+func IsArchive(filename string) bool {
+	return strings.HasSuffix(filename, ArchiveExt)
+}
+
+func (s *GzipStorage) SetVerify(verify bool) {
+	s.verify = verify
+}
+
+func (s *GzipStorage) Filename() string {
+	return s.filename
+}
+
+func (s *GzipStorage) Exists() bool {
+	_, err := os.Stat(s.filename)
+	return err == nil
+}
+
+func (s *GzipStorage) Save(bc *blockchain.Blockchain) error {
+	return fmt.Errorf("%s is an archived chain and is read-only (use -unarchive)", s.filename)
+}
+
+func (s *GzipStorage) Load() (*blockchain.Blockchain, error) {
+	if !s.Exists() {
+		return nil, nil
+	}
+
+	archive, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	header, blocks, err := decodeChain(archive.Chain)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.filename, err)
+	}
+
+	if header != nil && header.Base != nil {
+		return nil, fmt.Errorf("%s: archive holds a fork delta", s.filename)
+	}
+
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("%s: chain file has no blocks", s.filename)
+	}
+
+	bc := blockchain.NewBlockchain(blocks)
+	if s.verify {
+		if err := bc.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: verification failed: %w", s.filename, err)
+		}
+	}
+
+	return bc, nil
+}
+
+// ReadMeta decodes the metadata stored alongside the archived chain into v.
+func (s *GzipStorage) ReadMeta(v any) error {
+	archive, err := s.read()
+	if err != nil {
+		return err
+	}
+	if len(archive.Meta) == 0 {
+		return fmt.Errorf("%s: archive has no metadata", s.filename)
+	}
+	return json.Unmarshal(archive.Meta, v)
+}
+
+func (s *GzipStorage) read() (*archiveFile, error) {
+	f, err := os.Open(s.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.filename, err)
+	}
+	defer zr.Close()
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.filename, err)
+	}
+
+	var archive archiveFile
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return nil, fmt.Errorf("%s: malformed archive: %w", s.filename, err)
+	}
+
+	if checksum(archive.Chain) != archive.Checksum {
+		return nil, fmt.Errorf("%s: archive checksum mismatch", s.filename)
+	}
+
+	return &archive, nil
+}
+
+// WriteArchive writes bc and meta as a gzip-compressed, checksummed archive.
+func WriteArchive(filename string, bc *blockchain.Blockchain, meta any) error {
+	chain, err := json.Marshal(chainFile{
+		Header: NewHeader(bc),
+		Blocks: bc.Blocks(),
+	})
+	if err != nil {
+		return err
+	}
+
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(archiveFile{
+		Checksum: checksum(chain),
+		Meta:     metaBytes,
+		Chain:    chain,
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(f)
+	if _, err := zw.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+func testChain(t *testing.T, names ...string) *blockchain.Blockchain {
+	t.Helper()
+	bc := blockchain.NewBlockchain(nil)
+	for _, name := range names {
+		if _, err := bc.AddBlock(blockchain.StudentRecord{ID: name, FullName: name, Grade: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+type testMeta struct {
+	Name string `json:"name"`
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "blockchain_main.json"+ArchiveExt)
+	bc := testChain(t, "Иванов", "Петров")
+
+	if err := WriteArchive(filename, bc, testMeta{Name: "main"}); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := NewGzipStorage(filename)
+	archive.SetVerify(true)
+	restored, err := archive.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Length() != bc.Length() {
+		t.Fatalf("archive holds %d blocks, want %d", restored.Length(), bc.Length())
+	}
+	for i, block := range bc.Blocks() {
+		if restored.Blocks()[i].Hash != block.Hash {
+			t.Errorf("block #%d is %s after the round trip, want %s", i, restored.Blocks()[i].Hash, block.Hash)
+		}
+	}
+
+	var meta testMeta
+	if err := archive.ReadMeta(&meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Name != "main" {
+		t.Errorf("meta name is '%s', want 'main'", meta.Name)
+	}
+
+	if err := archive.Save(bc); err == nil {
+		t.Error("saving to an archive succeeded")
+	}
+	if err := WriteArchive(filename, bc, nil); err == nil {
+		t.Error("WriteArchive overwrote an existing archive")
+	}
+}
+
+func TestArchiveChecksumMismatch(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "blockchain_main.json"+ArchiveExt)
+	if err := WriteArchive(filename, testChain(t, "Иванов"), testMeta{Name: "main"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change a record inside the compressed payload, leaving the checksum
+	// as it was.
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte("Иванов"), []byte("Сидоров"), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatal("record not found in the archive payload")
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(tampered)
+	zw.Close()
+	if err := os.WriteFile(filename, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := NewGzipStorage(filename)
+	if _, err := archive.Load(); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("loading a tampered archive: %v, want a checksum mismatch", err)
+	}
+	var meta testMeta
+	if err := archive.ReadMeta(&meta); err == nil {
+		t.Error("reading the meta of a tampered archive succeeded")
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
@@ -9,16 +10,32 @@ import (
 
 type JSONStorage struct {
 	filename string
+	verify   bool
+	// broken is set when the file exists but could not be loaded, so that
+	// Save does not replace data that may still be recoverable.
+	broken bool
 }
 
 func NewJSONStorage(filename string) *JSONStorage {
 	return &JSONStorage{filename: filename}
 }
 
+// SetVerify makes Load validate the chain before returning it.
+func (s *JSONStorage) SetVerify(verify bool) {
+	s.verify = verify
+}
+
+func (s *JSONStorage) Filename() string {
+	return s.filename
+}
+
 func (s *JSONStorage) Save(bc *blockchain.Blockchain) error {
-	data := struct {
-		Blocks []*blockchain.Block `json:"blocks"`
-	}{
+	if s.broken {
+		return fmt.Errorf("refusing to overwrite %s: it failed to load (use -repair)", s.filename)
+	}
+
+	data := chainFile{
+		Header: NewHeader(bc),
 		Blocks: bc.Blocks(),
 	}
 
@@ -31,24 +48,59 @@ func (s *JSONStorage) Save(bc *blockchain.Blockchain) error {
 }
 
 func (s *JSONStorage) Load() (*blockchain.Blockchain, error) {
+	header, blocks, err := s.LoadDelta()
+	if err != nil || blocks == nil {
+		return nil, err
+	}
+
+	if header != nil && header.Base != nil {
+		return nil, fmt.Errorf("%s is a fork delta of '%s' and must be loaded through its lineage",
+			s.filename, header.Base.Chain)
+	}
+
+	bc := blockchain.NewBlockchain(blocks)
+	if s.verify {
+		if err := bc.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: verification failed: %w", s.filename, err)
+		}
+	}
+
+	return bc, nil
+}
+
+// LoadDelta reads the file without assembling a chain. For a fork delta
+// file header.Base is set and blocks start right after the base block; an
+// empty delta yields a non-nil, empty slice. A missing file yields nil
+// blocks.
+func (s *JSONStorage) LoadDelta() (*Header, []*blockchain.Block, error) {
 	if !s.Exists() {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	bytes, err := os.ReadFile(s.filename)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var data struct {
-		Blocks []*blockchain.Block
+	header, blocks, err := decodeChain(bytes)
+	if err != nil {
+		s.broken = true
+		return nil, nil, fmt.Errorf("%s: %w", s.filename, err)
 	}
 
-	if err := json.Unmarshal(bytes, &data); err != nil {
-		return nil, err
+	if header != nil && header.Base != nil {
+		if blocks == nil {
+			blocks = []*blockchain.Block{}
+		}
+		return header, blocks, nil
+	}
+
+	if len(blocks) == 0 {
+		s.broken = true
+		return nil, nil, fmt.Errorf("%s: chain file has no blocks", s.filename)
 	}
 
-	return blockchain.NewBlockchain(data.Blocks), nil
+	return header, blocks, nil
 }
 
 func (s *JSONStorage) Exists() bool {
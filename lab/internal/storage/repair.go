@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// Salvage reads as much of a chain file as decodes, for repairing files
+// written before the block store. It returns the header, if any, the blocks
+// decoded before the first error and that error; a file that cannot be read
+// yields no blocks and the read error.
+func (s *JSONStorage) Salvage() (*Header, []*blockchain.Block, error) {
+	data, err := os.ReadFile(s.filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodeLenient(data)
+}
+
+// decodeLenient reads blocks one by one and returns every block decoded
+// before the first error, so that a truncated or partially damaged file
+// still yields its intact prefix.
+func decodeLenient(data []byte) (*Header, []*blockchain.Block, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("not a chain file")
+	}
+
+	var header *Header
+	var blocks []*blockchain.Block
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return header, blocks, err
+		}
+
+		switch tok {
+		case "header":
+			if err := dec.Decode(&header); err != nil {
+				return header, blocks, fmt.Errorf("header: %w", err)
+			}
+			if err := header.Check(); err != nil {
+				return header, blocks, err
+			}
+
+		case "blocks":
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+				return header, blocks, fmt.Errorf("blocks: expected array")
+			}
+			for dec.More() {
+				block, err := decodeBlock(dec, header == nil)
+				if err != nil {
+					return header, blocks, fmt.Errorf("block #%d: %w", len(blocks), err)
+				}
+				blocks = append(blocks, block)
+			}
+			if _, err := dec.Token(); err != nil {
+				return header, blocks, err
+			}
+
+		default:
+			return header, blocks, fmt.Errorf("unknown field %v", tok)
+		}
+	}
+
+	return header, blocks, nil
+}
+
+func decodeBlock(dec *json.Decoder, legacy bool) (*blockchain.Block, error) {
+	if !legacy {
+		var block blockchain.Block
+		if err := dec.Decode(&block); err != nil {
+			return nil, err
+		}
+		return &block, nil
+	}
+
+	var lb legacyBlock
+	if err := dec.Decode(&lb); err != nil {
+		return nil, err
+	}
+	return lb.toBlock(), nil
+}
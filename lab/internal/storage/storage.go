@@ -6,4 +6,6 @@ type Storage interface {
 	Save(bc *blockchain.Blockchain) error
 	Load() (*blockchain.Blockchain, error)
 	Exists() bool
+	Filename() string
+	SetVerify(verify bool)
 }
@@ -8,34 +8,51 @@ import (
 )
 
 type Blockchain struct {
-	blocks []*Block
+	blocks    []*Block
+	observers []Observer
+}
+
+// Observer получает каждый блок, добавленный через AddBlock.
+type Observer interface {
+	BlockAdded(block *Block)
 }
 
 func NewBlockchain(blocks []*Block) *Blockchain {
 	if len(blocks) == 0 {
-		genesis := &Block{
-			Index:     0,
-			Timestamp: time.Now().Unix(),
-			Transactions: []StudentRecord{
-				{
-					FullName: "GENESIS",
-					Zachetka: "000000",
-					Group:    "GENESIS",
-					Subject:  "GENESIS",
-				},
-			},
-			PreviousHash: "0",
-			MerkleRoot:   "",
-		}
-		miner := NewMiner()
-		miner.Mine(genesis, "00")
-
-		blocks = []*Block{genesis}
+		blocks = []*Block{newGenesis("")}
 	}
 
 	return &Blockchain{blocks: blocks}
 }
 
+// NewAnchoredBlockchain создаёт новую цепочку, в genesis-транзакции которой
+// в качестве ID записан anchor. Так anchor попадает в MerkleRoot и хеш genesis.
+func NewAnchoredBlockchain(anchor string) *Blockchain {
+	return &Blockchain{blocks: []*Block{newGenesis(anchor)}}
+}
+
+func newGenesis(anchor string) *Block {
+	genesis := &Block{
+		Index:     0,
+		Timestamp: time.Now().Unix(),
+		Transactions: []StudentRecord{
+			{
+				ID:       anchor,
+				FullName: "GENESIS",
+				Zachetka: "000000",
+				Group:    "GENESIS",
+				Subject:  "GENESIS",
+			},
+		},
+		PreviousHash: "0",
+		MerkleRoot:   "",
+	}
+	miner := NewMiner()
+	miner.Mine(genesis, "00")
+
+	return genesis
+}
+
 func (bc *Blockchain) AddBlock(transactions []StudentRecord) (time.Duration, error) {
 	if len(bc.blocks) == 0 {
 		return 0, fmt.Errorf("blockchain has no blocks (corrupted)")
@@ -65,9 +82,16 @@ func (bc *Blockchain) AddBlock(transactions []StudentRecord) (time.Duration, err
 	mineTime := miner.Mine(newBlock, Difficulty)
 
 	bc.blocks = append(bc.blocks, newBlock)
+	for _, o := range bc.observers {
+		o.BlockAdded(newBlock)
+	}
 	return mineTime, nil
 }
 
+func (bc *Blockchain) Observe(o Observer) {
+	bc.observers = append(bc.observers, o)
+}
+
 func (bc *Blockchain) Blocks() []*Block {
 	return bc.blocks
 }
@@ -84,31 +108,46 @@ func (bc *Blockchain) GetBlock(index int) (*Block, error) {
 }
 
 func (bc *Blockchain) Validate() error {
-	for i := 1; i < len(bc.blocks); i++ {
-		current := bc.blocks[i]
-		prev := bc.blocks[i-1]
+	_, err := ValidPrefix(bc.blocks)
+	return err
+}
+
+// ValidPrefix возвращает число начальных блоков, образующих корректную цепочку,
+// и ошибку, на которой проверка остановилась.
+func ValidPrefix(blocks []*Block) (int, error) {
+	if len(blocks) == 0 {
+		return 0, fmt.Errorf("blockchain has no blocks")
+	}
+
+	if blocks[0].Hash != CalculateHash(blocks[0]) {
+		return 0, fmt.Errorf("genesis block: invalid hash")
+	}
+
+	for i := 1; i < len(blocks); i++ {
+		current := blocks[i]
+		prev := blocks[i-1]
 
 		// Проверяем хеш блока
 		recalculated := CalculateHash(current)
 		if current.Hash != recalculated {
-			return fmt.Errorf("block %d: invalid hash", current.Index)
+			return i, fmt.Errorf("block %d: invalid hash", current.Index)
 		}
 
 		// Проверяем связь с предыдущим блоком
 		if current.PreviousHash != prev.Hash {
-			return fmt.Errorf("block #%d: broken chain link", current.Index)
+			return i, fmt.Errorf("block #%d: broken chain link", current.Index)
 		}
 
 		// Проверяем proof-of-work
 		if current.Hash[:2] != Difficulty {
-			return fmt.Errorf("block %d: invalid proof-of-work", current.Index)
+			return i, fmt.Errorf("block %d: invalid proof-of-work", current.Index)
 		}
 
 		// Проверяем MerkleRoot
 		calculatedRoot := CalculateMerkleRoot(current.Transactions)
 		if current.MerkleRoot != calculatedRoot {
-			return fmt.Errorf("block %d: invalid merkle root", current.Index)
+			return i, fmt.Errorf("block %d: invalid merkle root", current.Index)
 		}
 	}
-	return nil
+	return len(blocks), nil
 }
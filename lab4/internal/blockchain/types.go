@@ -5,21 +5,21 @@ const (
 )
 
 type StudentRecord struct {
-	ID       string
-	FullName string
-	Zachetka string
-	Group    string
-	Subject  string
-	Course   int
-	Grade    int
+	ID       string `json:"id"`
+	FullName string `json:"full_name"`
+	Zachetka string `json:"zachetka"`
+	Group    string `json:"group"`
+	Subject  string `json:"subject"`
+	Course   int    `json:"course"`
+	Grade    int    `json:"grade"`
 }
 
 type Block struct {
-	Index        int
-	Timestamp    int64
-	Transactions []StudentRecord
-	PreviousHash string
-	Hash         string
-	MerkleRoot   string
-	Nonce        int
+	Index        int             `json:"index"`
+	Timestamp    int64           `json:"timestamp"`
+	Transactions []StudentRecord `json:"transactions"`
+	PreviousHash string          `json:"previous_hash"`
+	Hash         string          `json:"hash"`
+	MerkleRoot   string          `json:"merkle_root"`
+	Nonce        int             `json:"nonce"`
 }
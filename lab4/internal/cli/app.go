@@ -2,17 +2,18 @@ package cli
 
 import (
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/index"
 	"github.com/rx3lixir/lab_bc/internal/storage"
 )
 
 type App struct {
-	bc      *blockchain.Blockchain
-	storage storage.Storage
+	bc        *blockchain.Blockchain
+	storage   storage.Storage
+	index     *index.Index
+	indexFile string
 }
 
-func NewApp(filename string) (*App, error) {
-	store := storage.NewJSONStorage(filename)
-
+func NewApp(store storage.Storage) (*App, error) {
 	bc, err := store.Load()
 	if err != nil {
 		return nil, err
@@ -25,8 +26,19 @@ func NewApp(filename string) (*App, error) {
 		}
 	}
 
+	indexFile := index.PathFor(store.Filename())
+	idx := index.Load(indexFile)
+	if idx.Sync(bc) {
+		if err := idx.Save(indexFile); err != nil {
+			return nil, err
+		}
+	}
+	bc.Observe(idx)
+
 	return &App{
-		bc:      bc,
-		storage: store,
+		bc:        bc,
+		storage:   store,
+		index:     idx,
+		indexFile: indexFile,
 	}, nil
 }
@@ -5,6 +5,7 @@ import (
 
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
 	"github.com/rx3lixir/lab_bc/internal/merkle"
+	"github.com/rx3lixir/lab_bc/internal/storage"
 )
 
 func (a *App) CmdList() error {
@@ -38,10 +39,30 @@ func (a *App) CmdAdd(transactions []blockchain.StudentRecord) error {
 		return fmt.Errorf("failed to save blockchain: %w", err)
 	}
 
+	if err := a.index.Save(a.indexFile); err != nil {
+		return fmt.Errorf("failed to save search index: %w", err)
+	}
+
 	fmt.Printf("✓ Block mined successfully in %v\n", miningTime)
 	return nil
 }
 
+func (a *App) CmdSearch(query string) error {
+	hits := a.index.Search(query)
+	fmt.Printf("Found %d results\n\n", len(hits))
+
+	for _, hit := range hits {
+		block, err := a.bc.GetBlock(hit.Block)
+		if err != nil || hit.Tx >= len(block.Transactions) {
+			return fmt.Errorf("search index is out of date (block #%d, tx #%d)", hit.Block, hit.Tx)
+		}
+		tx := block.Transactions[hit.Tx]
+		fmt.Printf("[block #%d, tx #%d] %s | %s | %s | %s | course %d | grade %d\n",
+			hit.Block, hit.Tx, tx.FullName, tx.Zachetka, tx.Group, tx.Subject, tx.Course, tx.Grade)
+	}
+	return nil
+}
+
 func (a *App) CmdMerkleBuild(blockIndex int) error {
 	block, err := a.bc.GetBlock(blockIndex)
 	if err != nil {
@@ -146,6 +167,29 @@ func (a *App) CmdMerkleVerify(blockIndex, txIndex int) error {
 	return nil
 }
 
+func CmdRepair(store *storage.JSONStorage) error {
+	report, err := store.Repair()
+	if err != nil {
+		return fmt.Errorf("repair failed: %w", err)
+	}
+
+	if !report.Repaired() {
+		fmt.Printf("✓ %s is intact (%d blocks), nothing to repair\n", store.Filename(), report.Kept)
+		return nil
+	}
+
+	fmt.Printf("✗ %v\n", report.Reason)
+	fmt.Printf("✓ Kept %d blocks (#0-#%d)\n", report.Kept, report.Kept-1)
+	if len(report.Discarded) > 0 {
+		fmt.Printf("  Discarded %d blocks:\n", len(report.Discarded))
+		for _, b := range report.Discarded {
+			fmt.Printf("    #%d: %d transaction(s) (%s)\n", b.Index, len(b.Transactions), b.Hash)
+		}
+	}
+	fmt.Printf("  Backup: %s\n", report.Backup)
+	return nil
+}
+
 func PrintBlock(b *blockchain.Block) {
 	fmt.Printf("========== Block #%d ==========\n", b.Index)
 	fmt.Printf("Timestamp:    %d\n", b.Timestamp)
@@ -155,9 +199,20 @@ func PrintBlock(b *blockchain.Block) {
 		fmt.Printf("  [%d] %s - %s (Grade: %d)\n", i, tx.FullName, tx.Subject, tx.Grade)
 	}
 
-	fmt.Printf("MerkleRoot:   %s...\n", b.MerkleRoot[:16])
-	fmt.Printf("Hash:         %s...\n", b.Hash[:16])
-	fmt.Printf("PreviousHash: %s...\n", b.PreviousHash[:16])
+	if b.Index == 0 && len(b.Transactions) > 0 && b.Transactions[0].ID != "" {
+		fmt.Printf("Anchor:       %s\n", b.Transactions[0].ID)
+	}
+
+	fmt.Printf("MerkleRoot:   %s...\n", shortHash(b.MerkleRoot))
+	fmt.Printf("Hash:         %s...\n", shortHash(b.Hash))
+	fmt.Printf("PreviousHash: %s...\n", shortHash(b.PreviousHash))
 	fmt.Printf("Nonce:        %d\n", b.Nonce)
 	fmt.Println()
 }
+
+func shortHash(h string) string {
+	if len(h) > 16 {
+		return h[:16]
+	}
+	return h
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/rx3lixir/lab_bc/internal/blockchain"
+	"github.com/rx3lixir/lab_bc/internal/storage"
 )
 
 func Run() error {
@@ -15,9 +16,16 @@ func Run() error {
 		return nil
 	}
 
+	if os.Args[1] == "migrate" {
+		return runMigrate(os.Args[2:])
+	}
+
 	listFlag := flag.Bool("list", false, "List all blocks")
 	validateFlag := flag.Bool("validate", false, "Validate blockchain")
 	addFlag := flag.Bool("add", false, "Add new transaction(s)")
+	searchFlag := flag.String("search", "", "Search keyword or field:value")
+	repairFlag := flag.Bool("repair", false, "Truncate chain file to its last valid block")
+	verifyFlag := flag.Bool("verify", false, "Validate chain when loading it")
 
 	// Merkle команды
 	merkleBuildFlag := flag.Int("merkle-build", -1, "Build Merkle tree for block")
@@ -34,7 +42,14 @@ func Run() error {
 
 	flag.CommandLine.Parse(os.Args[1:])
 
-	app, err := NewApp("blockchain.json")
+	store := storage.NewJSONStorage("blockchain.json")
+	store.SetVerify(*verifyFlag)
+
+	if *repairFlag {
+		return CmdRepair(store)
+	}
+
+	app, err := NewApp(store)
 	if err != nil {
 		return fmt.Errorf("failed to initialize app: %w", err)
 	}
@@ -46,6 +61,9 @@ func Run() error {
 	case *validateFlag:
 		return app.CmdValidate()
 
+	case *searchFlag != "":
+		return app.CmdSearch(*searchFlag)
+
 	case *merkleBuildFlag >= 0:
 		return app.CmdMerkleBuild(*merkleBuildFlag)
 
@@ -136,11 +154,16 @@ func parseIntOrDefault(s string) int {
 func printUsage() {
 	fmt.Println("Blockchain with Merkle Tree - Lab Work")
 	fmt.Println("Usage: bc <command> [options]")
+	fmt.Println("       bc migrate -from <lab_data_dir> [-chain <name>] [-to <file>] [-batch <n>] [-report <file>]")
+	fmt.Println("       bc migrate -from <lab_chain.json> [-to <file>] [-batch <n>] [-report <file>]")
 	fmt.Println()
 	fmt.Println("Commands:")
 	fmt.Println("  -list                        List all blocks")
 	fmt.Println("  -validate                    Validate blockchain integrity")
 	fmt.Println("  -add                         Add new transaction(s) to blockchain")
+	fmt.Println("  -search <query>              Search records (words or name|zachetka|group|subject:value)")
+	fmt.Println("  -repair                      Truncate chain to last valid block (keeps a backup)")
+	fmt.Println("  -verify                      Validate chain when loading it (with any command)")
 	fmt.Println()
 	fmt.Println("Merkle Tree Commands (main lab focus):")
 	fmt.Println("  -merkle-build <block>        Build and display Merkle tree for block")
@@ -163,6 +186,10 @@ func printUsage() {
 	fmt.Println("     -zachetkas \"202434,202435,202436\" \\")
 	fmt.Println("     -subjects \"Математика,Физика,Химия\"")
 	fmt.Println()
+	fmt.Println("  # Search by word prefix or exact field")
+	fmt.Println("  bc -search \"иванов\"")
+	fmt.Println("  bc -search zachetka:202434")
+	fmt.Println()
 	fmt.Println("  # Build Merkle tree visualization")
 	fmt.Println("  bc -merkle-build 1")
 	fmt.Println()
@@ -171,4 +198,8 @@ func printUsage() {
 	fmt.Println()
 	fmt.Println("  # Verify transaction with SPV")
 	fmt.Println("  bc -merkle-verify 1,0")
+	fmt.Println()
+	fmt.Println("  # Migrate a lab chain, 3 records per block")
+	fmt.Println("  bc migrate -from ~/.config/lab-bc -chain main -batch 3")
+	fmt.Println("  bc migrate -from ../lab/blockchain_main.json -batch 3")
 }
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rx3lixir/lab_bc/internal/migrate"
+	"github.com/rx3lixir/lab_bc/internal/storage"
+)
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "Source: lab data directory or chain file in lab format")
+	chain := fs.String("chain", "main", "Chain to migrate from a lab data directory")
+	to := fs.String("to", "blockchain.json", "Target chain file")
+	batch := fs.Int("batch", 1, "Records per block")
+	reportFile := fs.String("report", "migration_report.json", "Mapping report file")
+	force := fs.Bool("force", false, "Overwrite existing target file")
+
+	fs.Parse(args)
+
+	if *from == "" {
+		return fmt.Errorf("source file is required (-from)")
+	}
+
+	store := storage.NewJSONStorage(*to)
+	if store.Exists() && !*force {
+		return fmt.Errorf("target file %s already exists (use -force to overwrite)", *to)
+	}
+
+	source := *from
+	var legacy []*migrate.LegacyBlock
+	if info, err := os.Stat(*from); err == nil && info.IsDir() {
+		source = fmt.Sprintf("%s (chain '%s')", *from, *chain)
+		legacy, err = migrate.LoadLabChain(*from, *chain)
+		if err != nil {
+			return err
+		}
+	} else {
+		legacy, err = migrate.LoadLegacy(*from)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Migrating %d blocks from %s (batch size %d)...\n", len(legacy), source, *batch)
+
+	bc, report, err := migrate.Migrate(legacy, *batch)
+	if err != nil {
+		return err
+	}
+	report.Source = source
+
+	if err := store.Save(bc); err != nil {
+		return fmt.Errorf("failed to save blockchain: %w", err)
+	}
+
+	if err := report.Save(*reportFile); err != nil {
+		return fmt.Errorf("failed to save report: %w", err)
+	}
+
+	fmt.Printf("✓ Migrated %d records into %d blocks\n", len(report.Mappings), report.NewCount)
+	fmt.Printf("  Source tip:  %s\n", report.SourceTip)
+	fmt.Printf("  New genesis: %s\n", report.Genesis)
+	fmt.Printf("  Report:      %s\n", *reportFile)
+	return nil
+}
@@ -0,0 +1,240 @@
+package index
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// KeyFields - поля, по которым возможен точный поиск вида "zachetka:202434".
+var KeyFields = []string{"name", "zachetka", "group", "subject"}
+
+// Hit - позиция записи: номер блока и номер транзакции в нём.
+type Hit struct {
+	Block int `json:"block"`
+	Tx    int `json:"tx"`
+}
+
+// Index - инвертированный индекс записей цепочки. Height и Tip описывают
+// последний проиндексированный блок, по ним Sync решает, можно ли дописать
+// индекс или его нужно перестроить.
+type Index struct {
+	Height int              `json:"height"`
+	Tip    string           `json:"tip"`
+	Tokens map[string][]Hit `json:"tokens"`
+	Keys   map[string][]Hit `json:"keys"`
+
+	grams map[string][]string
+}
+
+func New() *Index {
+	return &Index{
+		Tokens: make(map[string][]Hit),
+		Keys:   make(map[string][]Hit),
+	}
+}
+
+// PathFor возвращает имя файла индекса для файла цепочки.
+func PathFor(chainFile string) string {
+	return strings.TrimSuffix(chainFile, ".json") + ".index.json"
+}
+
+// Load читает индекс из файла. Отсутствующий или повреждённый файл даёт
+// пустой индекс: он производный и будет перестроен при Sync.
+func Load(filename string) *Index {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return New()
+	}
+
+	idx := New()
+	if err := json.Unmarshal(data, idx); err != nil || idx.Tokens == nil || idx.Keys == nil {
+		return New()
+	}
+	return idx
+}
+
+func (idx *Index) Save(filename string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0o644)
+}
+
+// Sync приводит индекс в соответствие с цепочкой: дописывает новые блоки,
+// если проиндексированная вершина всё ещё в цепочке, иначе строит заново.
+// Возвращает true, если индекс изменился.
+func (idx *Index) Sync(bc *blockchain.Blockchain) bool {
+	blocks := bc.Blocks()
+
+	if idx.Height > len(blocks) || (idx.Height > 0 && blocks[idx.Height-1].Hash != idx.Tip) {
+		*idx = *New()
+	}
+
+	if idx.Height == len(blocks) {
+		return false
+	}
+
+	for _, block := range blocks[idx.Height:] {
+		idx.BlockAdded(block)
+	}
+	return true
+}
+
+// BlockAdded индексирует очередной блок цепочки.
+func (idx *Index) BlockAdded(block *blockchain.Block) {
+	for i := range block.Transactions {
+		idx.addRecord(&block.Transactions[i], Hit{Block: block.Index, Tx: i})
+	}
+	idx.Height = block.Index + 1
+	idx.Tip = block.Hash
+	idx.grams = nil
+}
+
+func (idx *Index) addRecord(record *blockchain.StudentRecord, hit Hit) {
+	values := []string{record.FullName, record.Zachetka, record.Group, record.Subject}
+
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, token := range Tokenize(value) {
+			if !seen[token] {
+				seen[token] = true
+				idx.Tokens[token] = append(idx.Tokens[token], hit)
+			}
+		}
+	}
+
+	for i, field := range KeyFields {
+		key := Key(field, values[i])
+		idx.Keys[key] = append(idx.Keys[key], hit)
+	}
+}
+
+// Search ищет записи по запросу. Запрос вида "поле:значение" ищет точное
+// совпадение поля, иначе каждое слово запроса должно содержаться в
+// каком-либо слове записи: "ванов" находит "Иванов".
+func (idx *Index) Search(query string) []Hit {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+
+	if field, value, ok := strings.Cut(query, ":"); ok && isKeyField(field) {
+		return sortHits(append([]Hit(nil), idx.Keys[Key(field, value)]...))
+	}
+
+	tokens := Tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	result := idx.substringHits(tokens[0])
+	for _, token := range tokens[1:] {
+		if len(result) == 0 {
+			break
+		}
+		next := idx.substringHits(token)
+		for hit := range result {
+			if !next[hit] {
+				delete(result, hit)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(result))
+	for hit := range result {
+		hits = append(hits, hit)
+	}
+	return sortHits(hits)
+}
+
+// gramSize - длина n-грамм (в символах), по которым ищутся слова.
+const gramSize = 3
+
+// substringHits возвращает записи, в словах которых есть подстрока s.
+// Слова-кандидаты берутся по n-граммам s: слово, содержащее s, содержит и
+// каждую из них.
+func (idx *Index) substringHits(s string) map[Hit]bool {
+	if idx.grams == nil {
+		idx.buildGrams()
+	}
+
+	var terms []string
+	runes := []rune(s)
+	if len(runes) <= gramSize {
+		terms = idx.grams[s]
+	} else {
+		for i := 0; i+gramSize <= len(runes); i++ {
+			candidates := idx.grams[string(runes[i:i+gramSize])]
+			if i == 0 || len(candidates) < len(terms) {
+				terms = candidates
+			}
+		}
+	}
+
+	hits := make(map[Hit]bool)
+	for _, term := range terms {
+		if !strings.Contains(term, s) {
+			continue
+		}
+		for _, hit := range idx.Tokens[term] {
+			hits[hit] = true
+		}
+	}
+	return hits
+}
+
+// buildGrams сопоставляет каждой n-грамме длиной до gramSize слова, в
+// которых она встречается. Строится по Tokens и в файл не сохраняется.
+func (idx *Index) buildGrams() {
+	idx.grams = make(map[string][]string)
+	for term := range idx.Tokens {
+		runes := []rune(term)
+		seen := make(map[string]bool)
+		for n := 1; n <= gramSize; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				gram := string(runes[i : i+n])
+				if !seen[gram] {
+					seen[gram] = true
+					idx.grams[gram] = append(idx.grams[gram], term)
+				}
+			}
+		}
+	}
+}
+
+// Tokenize разбивает строку на слова в нижнем регистре.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Key возвращает ключ точного поиска для значения поля.
+func Key(field, value string) string {
+	return strings.ToLower(field) + ":" + strings.ToLower(strings.TrimSpace(value))
+}
+
+func isKeyField(field string) bool {
+	for _, f := range KeyFields {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortHits(hits []Hit) []Hit {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Block != hits[j].Block {
+			return hits[i].Block < hits[j].Block
+		}
+		return hits[i].Tx < hits[j].Tx
+	})
+	return hits
+}
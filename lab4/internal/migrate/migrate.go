@@ -0,0 +1,347 @@
+package migrate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+// AnchorPrefix предшествует хешу вершины исходной цепочки в ID genesis-транзакции.
+const AnchorPrefix = "migrated-from:"
+
+// LegacyFormat - имя формата файлов lab с заголовком.
+const LegacyFormat = "lab-bc/single-record"
+
+// Хранилище блоков lab: все цепочки каталога данных в одном файле, цепочка -
+// ссылка на вершину в конфиге.
+const (
+	StoreFormat  = "lab-bc/block-store"
+	StoreFile    = "blocks.json"
+	StoreConfig  = "fork_config.json"
+	storeVersion = 1
+)
+
+// LegacyBlock - блок в формате lab: одна запись на блок, без MerkleRoot.
+type LegacyBlock struct {
+	Index        int          `json:"index"`
+	Timestamp    int64        `json:"timestamp"`
+	Data         LegacyRecord `json:"data"`
+	PreviousHash string       `json:"previous_hash"`
+	Hash         string       `json:"hash"`
+	Nonce        int          `json:"nonce"`
+}
+
+// LegacyRecord - запись lab. Поля происхождения появились в версии 3;
+// они участвуют в хеше блока, но в формат lab4 не переносятся.
+type LegacyRecord struct {
+	blockchain.StudentRecord
+	IssuedAt   int64       `json:"issued_at,omitempty"`
+	Origin     string      `json:"origin,omitempty"`
+	Provenance []LegacyHop `json:"provenance,omitempty"`
+}
+
+type LegacyHop struct {
+	Chain      string `json:"chain"`
+	Block      int    `json:"block"`
+	Hash       string `json:"hash"`
+	Into       string `json:"into"`
+	ResolvedAt int64  `json:"resolved_at"`
+}
+
+// unversionedBlock - блок lab до появления заголовка формата.
+type unversionedBlock struct {
+	Index     int
+	Timestamp int64
+	Data      struct {
+		ID       string
+		FullName string
+		Zachetka string
+		Group    string
+		Subject  string
+		Course   int
+		Grade    int
+	}
+	PreviousHash string
+	Hash         string
+	Nonce        int
+}
+
+type Mapping struct {
+	OldIndex int    `json:"old_index"`
+	OldHash  string `json:"old_hash"`
+	RecordID string `json:"record_id"`
+	NewBlock int    `json:"new_block"`
+	NewHash  string `json:"new_hash"`
+	TxIndex  int    `json:"tx_index"`
+}
+
+type Report struct {
+	Source      string    `json:"source"`
+	SourceTip   string    `json:"source_tip"`
+	SourceCount int       `json:"source_blocks"`
+	Genesis     string    `json:"genesis"`
+	BatchSize   int       `json:"batch_size"`
+	NewCount    int       `json:"new_blocks"`
+	Mappings    []Mapping `json:"mappings"`
+}
+
+func LoadLegacy(filename string) ([]*LegacyBlock, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Header *struct {
+			Format  string          `json:"format"`
+			Version int             `json:"version"`
+			Base    json.RawMessage `json:"base"`
+		} `json:"header"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse legacy chain: %w", err)
+	}
+
+	var blocks []*LegacyBlock
+	if probe.Header != nil {
+		if probe.Header.Format == StoreFormat {
+			return nil, fmt.Errorf("%s is a lab block store; migrate its data directory instead (-from <dir> -chain <name>)", filename)
+		}
+		if probe.Header.Format != LegacyFormat || probe.Header.Version < 1 || probe.Header.Version > 3 {
+			return nil, fmt.Errorf("%s is %s v%d, expected %s v1-3",
+				filename, probe.Header.Format, probe.Header.Version, LegacyFormat)
+		}
+		if len(probe.Header.Base) > 0 {
+			return nil, fmt.Errorf("%s is a fork delta without its ancestor blocks; migrate a full chain file instead", filename)
+		}
+
+		var file struct {
+			Header json.RawMessage `json:"header"`
+			Blocks []*LegacyBlock  `json:"blocks"`
+		}
+		if err := decodeStrict(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse legacy chain: %w", err)
+		}
+		blocks = file.Blocks
+	} else {
+		var file struct {
+			Blocks []*unversionedBlock `json:"blocks"`
+		}
+		if err := decodeStrict(data, &file); err != nil {
+			return nil, fmt.Errorf("%s is not a lab chain file: %w", filename, err)
+		}
+		for _, ub := range file.Blocks {
+			blocks = append(blocks, &LegacyBlock{
+				Index:        ub.Index,
+				Timestamp:    ub.Timestamp,
+				Data:         LegacyRecord{StudentRecord: blockchain.StudentRecord(ub.Data)},
+				PreviousHash: ub.PreviousHash,
+				Hash:         ub.Hash,
+				Nonce:        ub.Nonce,
+			})
+		}
+	}
+
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("legacy chain %s has no blocks", filename)
+	}
+
+	return blocks, nil
+}
+
+// LoadLabChain читает цепочку name из каталога данных lab: вершину берёт из
+// конфига, блоки - из хранилища, проходя по PreviousHash до genesis.
+// Цепочка, ещё хранящаяся в собственном файле, читается через LoadLegacy.
+func LoadLabChain(dir, name string) ([]*LegacyBlock, error) {
+	data, err := os.ReadFile(filepath.Join(dir, StoreConfig))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a lab data directory: %w", dir, err)
+	}
+	var config struct {
+		Chains map[string]struct {
+			File string `json:"file"`
+			Tip  string `json:"tip"`
+		} `json:"chains"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", StoreConfig, err)
+	}
+
+	chain, ok := config.Chains[name]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("chain '%s' not found in %s", name, dir)
+	case strings.HasSuffix(chain.File, ".gz"):
+		return nil, fmt.Errorf("chain '%s' is archived; unarchive it with lab first", name)
+	case chain.File != "":
+		return LoadLegacy(filepath.Join(dir, chain.File))
+	case chain.Tip == "":
+		return nil, fmt.Errorf("chain '%s' has no blocks", name)
+	}
+
+	storeFile := filepath.Join(dir, StoreFile)
+	data, err = os.ReadFile(storeFile)
+	if err != nil {
+		return nil, err
+	}
+	var store struct {
+		Header *struct {
+			Format        string `json:"format"`
+			Version       int    `json:"version"`
+			HashAlgorithm string `json:"hash_algorithm"`
+			Difficulty    string `json:"difficulty"`
+		} `json:"header"`
+		Blocks []*LegacyBlock `json:"blocks"`
+	}
+	if err := decodeStrict(data, &store); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", storeFile, err)
+	}
+	if store.Header == nil || store.Header.Format != StoreFormat || store.Header.Version != storeVersion {
+		return nil, fmt.Errorf("%s is not a %s v%d file", storeFile, StoreFormat, storeVersion)
+	}
+
+	byHash := make(map[string]*LegacyBlock, len(store.Blocks))
+	for _, block := range store.Blocks {
+		byHash[block.Hash] = block
+	}
+
+	var reversed []*LegacyBlock
+	for hash := chain.Tip; ; {
+		block, ok := byHash[hash]
+		if !ok {
+			return nil, fmt.Errorf("block %s of chain '%s' is missing from %s", hash, name, storeFile)
+		}
+		reversed = append(reversed, block)
+		if block.Index == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	blocks := make([]*LegacyBlock, len(reversed))
+	for i, block := range reversed {
+		blocks[len(reversed)-1-i] = block
+	}
+	return blocks, nil
+}
+
+func ValidateLegacy(blocks []*LegacyBlock) error {
+	for i, block := range blocks {
+		if block.Hash != legacyHash(block) {
+			return fmt.Errorf("block %d: invalid hash", block.Index)
+		}
+		if i == 0 {
+			continue
+		}
+		if block.PreviousHash != blocks[i-1].Hash {
+			return fmt.Errorf("block #%d: broken chain link", block.Index)
+		}
+		if !strings.HasPrefix(block.Hash, blockchain.Difficulty) {
+			return fmt.Errorf("block %d: invalid proof-of-work", block.Index)
+		}
+	}
+	return nil
+}
+
+// Migrate переупаковывает записи legacy-цепочки в блоки по batchSize транзакций
+// и заново майнит их поверх genesis, привязанного к вершине исходной цепочки.
+func Migrate(blocks []*LegacyBlock, batchSize int) (*blockchain.Blockchain, *Report, error) {
+	if batchSize < 1 {
+		return nil, nil, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+
+	if err := ValidateLegacy(blocks); err != nil {
+		return nil, nil, fmt.Errorf("source chain is invalid: %w", err)
+	}
+
+	tip := blocks[len(blocks)-1].Hash
+	bc := blockchain.NewAnchoredBlockchain(AnchorPrefix + tip)
+
+	report := &Report{
+		SourceTip:   tip,
+		SourceCount: len(blocks),
+		Genesis:     bc.Blocks()[0].Hash,
+		BatchSize:   batchSize,
+	}
+
+	records := blocks[1:]
+	for start := 0; start < len(records); start += batchSize {
+		end := start + batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+
+		batch := make([]blockchain.StudentRecord, 0, end-start)
+		for _, old := range records[start:end] {
+			batch = append(batch, old.Data.StudentRecord)
+		}
+
+		if _, err := bc.AddBlock(batch); err != nil {
+			return nil, nil, fmt.Errorf("failed to mine block: %w", err)
+		}
+
+		newBlock := bc.Blocks()[bc.Length()-1]
+		for i, old := range records[start:end] {
+			report.Mappings = append(report.Mappings, Mapping{
+				OldIndex: old.Index,
+				OldHash:  old.Hash,
+				RecordID: newBlock.Transactions[i].ID,
+				NewBlock: newBlock.Index,
+				NewHash:  newBlock.Hash,
+				TxIndex:  i,
+			})
+		}
+	}
+
+	report.NewCount = bc.Length()
+	return bc, report, nil
+}
+
+func (r *Report) Save(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0o644)
+}
+
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// legacyHash повторяет CalculateHash из формата lab.
+func legacyHash(block *LegacyBlock) string {
+	record := fmt.Sprintf(
+		"%d%d%s%d%s%s%s%s%d%s%d",
+		block.Index,
+		block.Timestamp,
+		block.Data.ID,
+		block.Data.Grade,
+		block.Data.FullName,
+		block.Data.Zachetka,
+		block.Data.Group,
+		block.Data.Subject,
+		block.Data.Course,
+		block.PreviousHash,
+		block.Nonce,
+	)
+
+	if data := block.Data; data.IssuedAt != 0 || data.Origin != "" || len(data.Provenance) > 0 {
+		record += fmt.Sprintf("|%d|%s", data.IssuedAt, data.Origin)
+		for _, hop := range data.Provenance {
+			record += fmt.Sprintf("|%s:%d:%s:%s:%d", hop.Chain, hop.Block, hop.Hash, hop.Into, hop.ResolvedAt)
+		}
+	}
+
+	hash := sha256.Sum256([]byte(record))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+const (
+	FormatName    = "lab-bc/merkle"
+	FormatVersion = 1
+	HashAlgorithm = "sha256"
+)
+
+// Header describes the on-disk layout of a chain file. ChainID is the
+// genesis block hash.
+type Header struct {
+	Format        string `json:"format"`
+	Version       int    `json:"version"`
+	ChainID       string `json:"chain_id"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Difficulty    string `json:"difficulty"`
+}
+
+type chainFile struct {
+	Header *Header             `json:"header"`
+	Blocks []*blockchain.Block `json:"blocks"`
+}
+
+func NewHeader(bc *blockchain.Blockchain) *Header {
+	header := &Header{
+		Format:        FormatName,
+		Version:       FormatVersion,
+		HashAlgorithm: HashAlgorithm,
+		Difficulty:    blockchain.Difficulty,
+	}
+	if blocks := bc.Blocks(); len(blocks) > 0 {
+		header.ChainID = blocks[0].Hash
+	}
+	return header
+}
+
+func (h *Header) Check() error {
+	if h.Format != FormatName {
+		return fmt.Errorf("chain file format is %q, expected %q", h.Format, FormatName)
+	}
+	if h.Version < 1 || h.Version > FormatVersion {
+		return fmt.Errorf("unsupported %s version %d (supported: 1-%d)", FormatName, h.Version, FormatVersion)
+	}
+	if h.HashAlgorithm != HashAlgorithm {
+		return fmt.Errorf("unsupported hash algorithm %q", h.HashAlgorithm)
+	}
+	if h.Difficulty != blockchain.Difficulty {
+		return fmt.Errorf("chain difficulty %q does not match %q", h.Difficulty, blockchain.Difficulty)
+	}
+	return nil
+}
+
+// decodeChain parses a chain file. Files without a header are the
+// unversioned layout with capitalized Go field names; both layouts reject
+// unknown fields so that a file of another format fails loudly instead of
+// decoding into zero values.
+func decodeChain(data []byte) ([]*blockchain.Block, error) {
+	var probe struct {
+		Header *Header `json:"header"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+
+	if probe.Header == nil {
+		blocks, err := decodeLegacy(data)
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized chain file (not a %s file?): %w", FormatName, err)
+		}
+		return blocks, nil
+	}
+
+	if err := probe.Header.Check(); err != nil {
+		return nil, err
+	}
+
+	var file chainFile
+	if err := decodeStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("malformed %s file: %w", FormatName, err)
+	}
+
+	if len(file.Blocks) > 0 && file.Blocks[0].Hash != file.Header.ChainID {
+		return nil, fmt.Errorf("genesis hash does not match chain ID %s", file.Header.ChainID)
+	}
+
+	return file.Blocks, nil
+}
+
+type legacyRecord struct {
+	ID       string
+	FullName string
+	Zachetka string
+	Group    string
+	Subject  string
+	Course   int
+	Grade    int
+}
+
+type legacyBlock struct {
+	Index        int
+	Timestamp    int64
+	Transactions []legacyRecord
+	PreviousHash string
+	Hash         string
+	MerkleRoot   string
+	Nonce        int
+}
+
+func decodeLegacy(data []byte) ([]*blockchain.Block, error) {
+	var file struct {
+		Blocks []*legacyBlock `json:"blocks"`
+	}
+	if err := decodeStrict(data, &file); err != nil {
+		return nil, err
+	}
+
+	blocks := make([]*blockchain.Block, 0, len(file.Blocks))
+	for _, lb := range file.Blocks {
+		blocks = append(blocks, lb.toBlock())
+	}
+	return blocks, nil
+}
+
+func (lb *legacyBlock) toBlock() *blockchain.Block {
+	transactions := make([]blockchain.StudentRecord, 0, len(lb.Transactions))
+	for _, tx := range lb.Transactions {
+		transactions = append(transactions, blockchain.StudentRecord(tx))
+	}
+
+	return &blockchain.Block{
+		Index:        lb.Index,
+		Timestamp:    lb.Timestamp,
+		Transactions: transactions,
+		PreviousHash: lb.PreviousHash,
+		Hash:         lb.Hash,
+		MerkleRoot:   lb.MerkleRoot,
+		Nonce:        lb.Nonce,
+	}
+}
+
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rx3lixir/lab_bc/internal/blockchain"
+)
+
+type RepairReport struct {
+	Backup    string
+	Kept      int
+	Discarded []*blockchain.Block
+	// Reason is the parse or validation error that ended the valid prefix.
+	Reason error
+}
+
+func (r *RepairReport) Repaired() bool {
+	return r.Reason != nil
+}
+
+// Repair truncates the chain file to its last valid block. The original file
+// is copied to a timestamped backup before being rewritten.
+func (s *JSONStorage) Repair() (*RepairReport, error) {
+	data, err := os.ReadFile(s.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, parseErr := decodeLenient(data)
+	kept, validErr := blockchain.ValidPrefix(blocks)
+
+	report := &RepairReport{Kept: kept, Discarded: blocks[kept:]}
+	switch {
+	case validErr != nil:
+		report.Reason = validErr
+	case parseErr != nil:
+		report.Reason = parseErr
+	default:
+		return report, nil
+	}
+
+	if kept == 0 {
+		return report, fmt.Errorf("%s: no valid blocks to keep: %w", s.filename, report.Reason)
+	}
+
+	report.Backup = fmt.Sprintf("%s.bak-%d", s.filename, time.Now().Unix())
+	if err := os.WriteFile(report.Backup, data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	s.broken = false
+	if err := s.Save(blockchain.NewBlockchain(blocks[:kept])); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// decodeLenient reads blocks one by one and returns every block decoded
+// before the first error, so that a truncated or partially damaged file
+// still yields its intact prefix.
+func decodeLenient(data []byte) ([]*blockchain.Block, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("not a chain file")
+	}
+
+	var header *Header
+	var blocks []*blockchain.Block
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return blocks, err
+		}
+
+		switch tok {
+		case "header":
+			if err := dec.Decode(&header); err != nil {
+				return blocks, fmt.Errorf("header: %w", err)
+			}
+			if err := header.Check(); err != nil {
+				return blocks, err
+			}
+
+		case "blocks":
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+				return blocks, fmt.Errorf("blocks: expected array")
+			}
+			for dec.More() {
+				block, err := decodeBlock(dec, header == nil)
+				if err != nil {
+					return blocks, fmt.Errorf("block #%d: %w", len(blocks), err)
+				}
+				blocks = append(blocks, block)
+			}
+			if _, err := dec.Token(); err != nil {
+				return blocks, err
+			}
+
+		default:
+			return blocks, fmt.Errorf("unknown field %v", tok)
+		}
+	}
+
+	return blocks, nil
+}
+
+func decodeBlock(dec *json.Decoder, legacy bool) (*blockchain.Block, error) {
+	if !legacy {
+		var block blockchain.Block
+		if err := dec.Decode(&block); err != nil {
+			return nil, err
+		}
+		return &block, nil
+	}
+
+	var lb legacyBlock
+	if err := dec.Decode(&lb); err != nil {
+		return nil, err
+	}
+	return lb.toBlock(), nil
+}
@@ -6,4 +6,5 @@ type Storage interface {
 	Save(bc *blockchain.Blockchain) error
 	Load() (*blockchain.Blockchain, error)
 	Exists() bool
+	Filename() string
 }